@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Shamanskiy/lenslocked/src/http/server"
 	"github.com/Shamanskiy/lenslocked/src/models"
@@ -53,7 +55,38 @@ func loadEnvConfig() (server.Config, error) {
 	cfg.CSRF.Key = os.Getenv("CSRF_KEY")
 	cfg.CSRF.Secure = os.Getenv("CSRF_SECURE") == "true"
 
+	// the cookie signing key is optional and falls back to the CSRF key
+	cfg.Cookie.SigningKey = os.Getenv("COOKIE_SIGNING_KEY")
+	if cfg.Cookie.SigningKey == "" {
+		cfg.Cookie.SigningKey = cfg.CSRF.Key
+	}
+
 	cfg.Server.Address = os.Getenv("SERVER_ADDRESS")
+	// the base URL is optional for local setups without a proxy in front
+	cfg.Server.BaseURL = strings.TrimSuffix(os.Getenv("SERVER_BASE_URL"), "/")
+	if cfg.Server.BaseURL == "" {
+		cfg.Server.BaseURL = "http://" + cfg.Server.Address
+	}
+
+	// the default storage quota is optional, models provide a sane default
+	quotaStr := os.Getenv("STORAGE_QUOTA_MB")
+	if quotaStr != "" {
+		quotaMB, err := strconv.ParseInt(quotaStr, 10, 64)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Storage.DefaultQuota = quotaMB << 20
+	}
+
+	// the trash retention is optional as well
+	retentionStr := os.Getenv("TRASH_RETENTION_DAYS")
+	if retentionStr != "" {
+		retentionDays, err := strconv.Atoi(retentionStr)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Storage.TrashRetention = time.Duration(retentionDays) * 24 * time.Hour
+	}
 
 	return cfg, nil
 }
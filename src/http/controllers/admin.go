@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type Admin struct {
+	Templates struct {
+		Users   Template
+		Reports Template
+	}
+	QuotaService  *models.QuotaService
+	UserService   *models.UserService
+	ReportService *models.ReportService
+	EmailService  *models.EmailService
+}
+
+type usageData struct {
+	UserID      int
+	Email       string
+	Used        string
+	Quota       string
+	QuotaMB     string
+	Percent     int
+	CustomQuota bool
+}
+
+func newUsageData(usage models.StorageUsage) usageData {
+	data := usageData{
+		UserID:      usage.UserID,
+		Email:       usage.Email,
+		Used:        formatBytes(usage.Used),
+		Quota:       formatBytes(usage.Quota),
+		Percent:     usage.Percent(),
+		CustomQuota: usage.CustomQuota,
+	}
+	if usage.CustomQuota {
+		data.QuotaMB = strconv.FormatInt(usage.Quota>>20, 10)
+	}
+	return data
+}
+
+// This handler expects to sit behind userMiddleware.RequireAdmin
+func (a Admin) UsersHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderUsers(w, r)
+}
+
+func (a Admin) renderUsers(w http.ResponseWriter, r *http.Request, errs ...error) {
+	usages, err := a.QuotaService.AllUsages()
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		Users []usageData
+	}
+	for _, usage := range usages {
+		data.Users = append(data.Users, newUsageData(usage))
+	}
+	a.Templates.Users.Execute(w, r, data, errs...)
+}
+
+// SetQuotaHandler overrides the storage quota of a user. The quota is given
+// in megabytes, and an empty value resets the user to the default quota.
+func (a Admin) SetQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	var quota *int64
+	quotaMB := strings.TrimSpace(r.FormValue("quota"))
+	if quotaMB != "" {
+		mb, err := strconv.ParseInt(quotaMB, 10, 64)
+		// larger quotas don't fit into bytes
+		if err != nil || mb < 0 || mb > math.MaxInt64>>20 {
+			err = errors.Public(fmt.Errorf("invalid quota %q", quotaMB),
+				"Quota must be a whole number of megabytes.")
+			a.renderUsers(w, r, err)
+			return
+		}
+		bytes := mb << 20
+		quota = &bytes
+	}
+
+	err = a.QuotaService.SetQuota(userID, quota)
+	if err != nil {
+		if errors.Is(err, models.ErrResourceNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusFound)
+}
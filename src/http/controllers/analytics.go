@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+const (
+	// analyticsDays is the number of days covered by the views on the edit
+	// page.
+	analyticsDays = 30
+	// topImagesLimit is the number of most viewed images on the edit page.
+	topImagesLimit = 8
+)
+
+// botUserAgents are parts of the user agents of crawlers, link previews and
+// scripts, which don't count as visitors. Most of them say so themselves.
+var botUserAgents = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "facebookexternalhit",
+	"headless", "lighthouse", "curl", "wget", "python", "go-http-client",
+}
+
+// isBot reports whether the request comes from a bot, or is a prefetch the
+// user may never look at.
+func isBot(r *http.Request) bool {
+	agent := strings.ToLower(r.UserAgent())
+	if agent == "" {
+		return true
+	}
+	for _, bot := range botUserAgents {
+		if strings.Contains(agent, bot) {
+			return true
+		}
+	}
+	purpose := r.Header.Get("Sec-Purpose") + r.Header.Get("Purpose")
+	return strings.Contains(purpose, "prefetch")
+}
+
+// visitor identifies the client for the analytics. It is hashed with a salt
+// that changes daily before it is stored.
+func visitor(r *http.Request) string {
+	return clientIP(r) + " " + r.UserAgent()
+}
+
+// countsAsVisit reports whether the request counts for the analytics of the
+// gallery. Only published galleries have analytics, and bots are left out.
+func countsAsVisit(r *http.Request, gallery *models.Gallery) bool {
+	return gallery.Published && !isBot(r)
+}
+
+// recordImageView counts the view of the image page for the analytics of
+// the owner. Views by the owner don't count. The image files themselves are
+// loaded by every page showing them, so only the image page counts as
+// someone looking at the image.
+func (g Galleries) recordImageView(r *http.Request, gallery *models.Gallery, image models.Image) {
+	user := context.User(r.Context())
+	if user != nil && user.ID == gallery.UserID {
+		return
+	}
+	if !countsAsVisit(r, gallery) {
+		return
+	}
+	err := g.AnalyticsService.RecordImageView(gallery.ID, image.ID, visitor(r))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+type dayViewsData struct {
+	Date  string
+	Views int
+	// Percent is the height of the bar in the chart relative to the day with
+	// the most views.
+	Percent int
+}
+
+type imageViewsData struct {
+	imageData
+	Views int
+}
+
+// viewsData is the analytics section of the edit page.
+type viewsData struct {
+	Days int
+	// From and To are the first and the last day in the chart.
+	From      string
+	To        string
+	Total     int
+	Daily     []dayViewsData
+	TopImages []imageViewsData
+}
+
+func (g Galleries) viewsData(gallery *models.Gallery) (*viewsData, error) {
+	daily, err := g.AnalyticsService.DailyViews(gallery.ID, analyticsDays)
+	if err != nil {
+		return nil, err
+	}
+	topImages, err := g.AnalyticsService.TopImages(gallery.ID, analyticsDays, topImagesLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	data := viewsData{Days: analyticsDays}
+	most := 0
+	for _, day := range daily {
+		data.Total += day.Views
+		if day.Views > most {
+			most = day.Views
+		}
+	}
+	for _, day := range daily {
+		percent := 0
+		if most > 0 {
+			percent = day.Views * 100 / most
+		}
+		data.Daily = append(data.Daily, dayViewsData{
+			Date:    day.Day.Format("Jan 2"),
+			Views:   day.Views,
+			Percent: percent,
+		})
+	}
+	if len(data.Daily) > 0 {
+		data.From = data.Daily[0].Date
+		data.To = data.Daily[len(data.Daily)-1].Date
+	}
+	for _, image := range topImages {
+		data.TopImages = append(data.TopImages, imageViewsData{
+			imageData: newImageData(image.Image),
+			Views:     image.Views,
+		})
+	}
+	return &data, nil
+}
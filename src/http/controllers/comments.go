@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type commentData struct {
+	ID        int
+	GalleryID int
+	ImageKey  string
+	// Path is the page the comment is shown on. Moderation forms send the
+	// user back there.
+	Path         string
+	AuthorHandle string
+	// Body is Markdown and has to be rendered with the markdown function.
+	Body      string
+	CreatedAt string
+	Hidden    bool
+	CanReply  bool
+	CanHide   bool
+	CanDelete bool
+	CanReport bool
+	Replies   []commentData
+}
+
+// commentsData is the comment section of a gallery or image page.
+type commentsData struct {
+	GalleryID int
+	Path      string
+	// ImageKey is empty for comments on the gallery itself.
+	ImageKey string
+	// Visible is false for galleries that are not published, which can't
+	// have comments.
+	Visible bool
+	// Open is false if the owner turned comments off. Existing comments are
+	// still shown.
+	Open      bool
+	SignedIn  bool
+	MaxLength int
+	Comments  []commentData
+}
+
+// commentsData loads the comments on the gallery, or on the image if it is
+// not nil. Those who can moderate the comments also see the hidden ones.
+func (g Galleries) commentsData(r *http.Request, gallery *models.Gallery, image *models.Image) (commentsData, error) {
+	user := context.User(r.Context())
+	data := commentsData{
+		GalleryID: gallery.ID,
+		Path:      gallery.Path(),
+		Visible:   gallery.Published,
+		Open:      gallery.Published && gallery.CommentsEnabled,
+		SignedIn:  user != nil,
+		MaxLength: models.MaxCommentLength,
+	}
+	imageID := 0
+	if image != nil {
+		data.Path = imagePagePath(gallery, image.Key)
+		data.ImageKey = image.Key
+		imageID = image.ID
+	}
+	if !data.Visible {
+		return data, nil
+	}
+
+	role, err := g.role(r, gallery)
+	if err != nil {
+		return data, err
+	}
+	moderate := role.Can(models.ActionModerateComments)
+	comments, err := g.CommentService.Thread(gallery.ID, imageID, moderate)
+	if err != nil {
+		return data, err
+	}
+	data.Comments = data.newCommentsData(comments, user, moderate)
+	return data, nil
+}
+
+func (data commentsData) newCommentsData(comments []models.Comment, user *models.User, moderate bool) []commentData {
+	var result []commentData
+	for _, comment := range comments {
+		result = append(result, commentData{
+			ID:           comment.ID,
+			GalleryID:    comment.GalleryID,
+			ImageKey:     data.ImageKey,
+			Path:         data.Path,
+			AuthorHandle: comment.UserHandle,
+			Body:         comment.Body,
+			CreatedAt:    comment.CreatedAt.Format("Jan 2, 2006 15:04"),
+			Hidden:       comment.Hidden,
+			CanReply: data.Open && data.SignedIn && !comment.Hidden &&
+				comment.Depth < models.MaxCommentDepth,
+			CanHide:   moderate,
+			CanDelete: moderate || (user != nil && user.ID == comment.UserID),
+			CanReport: user != nil && user.ID != comment.UserID,
+			Replies:   data.newCommentsData(comment.Replies, user, moderate),
+		})
+	}
+	return result
+}
+
+// commentsMustBeOpen only lets comments through on published galleries that
+// have comments turned on.
+func (g Galleries) commentsMustBeOpen(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if !gallery.Published || !gallery.CommentsEnabled {
+		http.Error(w, "Comments are turned off for this gallery", http.StatusForbidden)
+		return fmt.Errorf("comments are turned off for this gallery")
+	}
+	return nil
+}
+
+// CreateCommentHandler adds a comment to the gallery, or to one of its
+// images if the form names one. Replies name their parent comment. The
+// number of comments is limited per user to slow down spam.
+func (g Galleries) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked, g.commentsMustBeOpen)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+
+	var image *models.Image
+	path := gallery.Path()
+	if key := r.FormValue("image"); key != "" {
+		found, err := g.GalleryService.Image(gallery.ID, key)
+		if err != nil {
+			if errors.Is(err, models.ErrImageNotFound) {
+				http.Error(w, "Image not found", http.StatusNotFound)
+				return
+			}
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		image = &found
+		path = imagePagePath(gallery, found.Key)
+	}
+	render := func(err error) {
+		if image != nil {
+			g.renderViewImage(w, r, gallery, *image, err)
+			return
+		}
+		g.renderViewGallery(w, r, gallery, err)
+	}
+
+	parentID := 0
+	if value := r.FormValue("parent_id"); value != "" {
+		parentID, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid comment", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the comment is counted before it is saved, so parallel requests can't
+	// all pass the limit
+	limitKey := strconv.Itoa(user.ID)
+	if !g.CommentLimiter.Hit(limitKey) {
+		minutes := math.Ceil(g.CommentLimiter.RetryAfter(limitKey).Minutes())
+		render(errors.Public(fmt.Errorf("too many comments"),
+			fmt.Sprintf("You are commenting too fast. Please try again in %.0f minutes.", minutes)))
+		return
+	}
+
+	imageID := 0
+	if image != nil {
+		imageID = image.ID
+	}
+	comment, err := g.CommentService.Create(gallery.ID, imageID, parentID, user.ID, r.FormValue("body"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidComment):
+			err = errors.Public(err, fmt.Sprintf(
+				"Comments can't be empty or longer than %d characters.", models.MaxCommentLength))
+		case errors.Is(err, models.ErrCommentNotFound):
+			err = errors.Public(err, "The comment you replied to is no longer available.")
+		default:
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		render(err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s#comment-%d", path, comment.ID), http.StatusFound)
+}
+
+// HideCommentHandler hides a comment from everyone but the moderators of the
+// gallery.
+func (g Galleries) HideCommentHandler(w http.ResponseWriter, r *http.Request) {
+	g.setCommentHidden(w, r, true)
+}
+
+// ShowCommentHandler makes a hidden comment visible again.
+func (g Galleries) ShowCommentHandler(w http.ResponseWriter, r *http.Request) {
+	g.setCommentHidden(w, r, false)
+}
+
+func (g Galleries) setCommentHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionModerateComments))
+	if err != nil {
+		return
+	}
+	comment, err := g.commentByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+	err = g.CommentService.SetHidden(gallery.ID, comment.ID, hidden)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	path := safeRedirectPath(r.FormValue("next"), gallery.Path())
+	http.Redirect(w, r, fmt.Sprintf("%s#comment-%d", path, comment.ID), http.StatusFound)
+}
+
+// DeleteCommentHandler removes a comment and all replies to it. Authors can
+// delete their own comments, moderators any comment on the gallery.
+func (g Galleries) DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery)
+	if err != nil {
+		return
+	}
+	comment, err := g.commentByID(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	role, err := g.role(r, gallery)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	user := context.User(r.Context())
+	if comment.UserID != user.ID && !role.Can(models.ActionModerateComments) {
+		http.Error(w, "You are not authorized to do this", http.StatusForbidden)
+		return
+	}
+
+	err = g.CommentService.Delete(gallery.ID, comment.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	path := safeRedirectPath(r.FormValue("next"), gallery.Path())
+	http.Redirect(w, r, path+"#comments", http.StatusFound)
+}
+
+// commentByID looks up the comment in the URL among the comments of the
+// gallery.
+func (g Galleries) commentByID(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.Comment, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "commentID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return nil, err
+	}
+	comment, err := g.CommentService.Find(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrCommentNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return nil, err
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, err
+	}
+	return comment, nil
+}
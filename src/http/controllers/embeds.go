@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+// The size of embedded galleries unless the consumer asks for a smaller one.
+const (
+	embedWidth  = 800
+	embedHeight = 600
+)
+
+type embedData struct {
+	Title       string
+	OwnerHandle string
+	// URL is the full URL of the gallery page, as embeds are shown on other
+	// sites.
+	URL    string
+	Images []imageData
+}
+
+// embeddable tells if other sites may embed the gallery. Only galleries
+// everyone can see are embeddable.
+func embeddable(gallery *models.Gallery) bool {
+	return gallery.Published && !gallery.OwnerSuspended && !gallery.PasswordProtected()
+}
+
+func (g Galleries) galleryMustBeEmbeddable(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if !embeddable(gallery) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return fmt.Errorf("gallery is not embeddable")
+	}
+	return nil
+}
+
+// embedURL returns the URL of the embeddable view of the gallery.
+func (g Galleries) embedURL(gallery *models.Gallery) string {
+	return fmt.Sprintf("%s/embed/galleries/%d", g.BaseURL, gallery.ID)
+}
+
+// oEmbedURL returns the URL where oEmbed consumers find the embed code of the
+// gallery.
+func (g Galleries) oEmbedURL(gallery *models.Gallery) string {
+	return g.BaseURL + "/oembed?format=json&url=" + url.QueryEscape(g.BaseURL+gallery.Path())
+}
+
+// embedCode returns the HTML snippet that embeds the gallery in other sites.
+func (g Galleries) embedCode(gallery *models.Gallery, width, height int) string {
+	return fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s" style="border:0" loading="lazy" allowfullscreen></iframe>`,
+		g.embedURL(gallery), width, height, html.EscapeString(gallery.Title))
+}
+
+// embedSize scales the default embed size down to fit the maximum width and
+// height, keeping the aspect ratio. A maximum of 0 means there is none.
+func embedSize(maxWidth, maxHeight int) (int, int) {
+	width, height := embedWidth, embedHeight
+	if maxWidth > 0 && maxWidth < width {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+	if maxHeight > 0 && maxHeight < height {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+	return width, height
+}
+
+// EmbedHandler shows the gallery as a slideshow for other sites to embed in
+// a frame. It is the only page allowed in frames, so it has no buttons that
+// change anything.
+func (g Galleries) EmbedHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.galleryMustBeEmbeddable)
+	if err != nil {
+		return
+	}
+	images, err := g.GalleryService.Images(gallery.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	g.recordView(r, gallery)
+	data := embedData{
+		Title:       gallery.Title,
+		OwnerHandle: gallery.UserHandle,
+		URL:         g.BaseURL + gallery.Path(),
+	}
+	for _, image := range images {
+		data.Images = append(data.Images, newImageData(image))
+	}
+	g.Templates.Embed.Execute(w, r, data)
+}
+
+// oEmbedResponse is the embed code of a gallery as described by the oEmbed
+// spec at https://oembed.com.
+type oEmbedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	AuthorURL    string `json:"author_url"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// OEmbedHandler lets sites that support oEmbed turn links to galleries into
+// embedded slideshows. Only JSON responses are supported.
+func (g Galleries) OEmbedHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format != "" && format != "json" {
+		http.Error(w, "Only the json format is supported", http.StatusNotImplemented)
+		return
+	}
+	gallery, err := g.galleryByURL(r.FormValue("url"))
+	if err == nil && !embeddable(gallery) {
+		err = models.ErrResourceNotFound
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrResourceNotFound) {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	// invalid maximums are ignored, like missing ones
+	maxWidth, _ := strconv.Atoi(r.FormValue("maxwidth"))
+	maxHeight, _ := strconv.Atoi(r.FormValue("maxheight"))
+	width, height := embedSize(maxWidth, maxHeight)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oEmbedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		Title:        gallery.Title,
+		AuthorName:   gallery.UserHandle,
+		AuthorURL:    g.BaseURL + "/u/" + gallery.UserHandle,
+		ProviderName: "Lenslocked",
+		ProviderURL:  g.BaseURL,
+		HTML:         g.embedCode(gallery, width, height),
+		Width:        width,
+		Height:       height,
+	})
+}
+
+// galleryByURL looks up the gallery a URL on this site points to. Both the
+// gallery page and the short /galleries/{id} URL are understood.
+func (g Galleries) galleryByURL(rawURL string) (*models.Gallery, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, models.ErrResourceNotFound
+	}
+	base, err := url.Parse(g.BaseURL)
+	if err != nil || u.Host != base.Host {
+		return nil, models.ErrResourceNotFound
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "galleries":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, models.ErrResourceNotFound
+		}
+		return g.GalleryService.FindByID(id)
+	case len(parts) == 3 && parts[0] == "u":
+		return g.GalleryService.FindBySlug(parts[1], parts[2])
+	default:
+		return nil, models.ErrResourceNotFound
+	}
+}
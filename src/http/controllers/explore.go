@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+// explorePageSize is the number of galleries on each page of the explore
+// page.
+const explorePageSize = 24
+
+// ExploreHandler lists discoverable galleries, newest first or most viewed
+// first depending on the sort query parameter. The after query parameter is
+// the cursor of the page to show.
+func (g Galleries) ExploreHandler(w http.ResponseWriter, r *http.Request) {
+	sort := models.ExploreSort(r.FormValue("sort"))
+	if sort != models.ExplorePopular {
+		sort = models.ExploreNewest
+	}
+
+	page, err := g.GalleryService.Explore(sort, r.FormValue("after"), explorePageSize)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCursor) {
+			http.Redirect(w, r, "/explore?sort="+string(sort), http.StatusFound)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		Sort      models.ExploreSort
+		Galleries []models.Gallery
+		Next      string
+	}
+	data.Sort = sort
+	data.Galleries = page.Galleries
+	data.Next = page.Next
+	g.Templates.Explore.Execute(w, r, data)
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+// mostFavoritedLimit is the number of images in the favorites breakdown on
+// the edit page.
+const mostFavoritedLimit = 10
+
+type favoriteImageData struct {
+	imageData
+	GalleryTitle string
+	GalleryPath  string
+}
+
+// FavoritesHandler lists the galleries and images the current user
+// favorited.
+//
+// This handler expects to sit behind userMiddleware.RequireUser
+func (g Galleries) FavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	galleries, err := g.FavoriteService.Galleries(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	images, err := g.FavoriteService.Images(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		Galleries []models.Gallery
+		Images    []favoriteImageData
+	}
+	data.Galleries = galleries
+	for _, image := range images {
+		data.Images = append(data.Images, favoriteImageData{
+			imageData:    newImageData(image.Image),
+			GalleryTitle: image.GalleryTitle,
+			GalleryPath:  image.GalleryPath,
+		})
+	}
+	g.Templates.Favorites.Execute(w, r, data)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (g Galleries) FavoriteGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	g.setGalleryFavorite(w, r, true)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (g Galleries) UnfavoriteGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	g.setGalleryFavorite(w, r, false)
+}
+
+func (g Galleries) setGalleryFavorite(w http.ResponseWriter, r *http.Request, favorite bool) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if favorite {
+		err = g.FavoriteService.FavoriteGallery(user.ID, gallery.ID)
+	} else {
+		err = g.FavoriteService.UnfavoriteGallery(user.ID, gallery.ID)
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, safeRedirectPath(r.FormValue("next"), gallery.Path()), http.StatusFound)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (g Galleries) FavoriteImageHandler(w http.ResponseWriter, r *http.Request) {
+	g.setImageFavorite(w, r, true)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (g Galleries) UnfavoriteImageHandler(w http.ResponseWriter, r *http.Request) {
+	g.setImageFavorite(w, r, false)
+}
+
+func (g Galleries) setImageFavorite(w http.ResponseWriter, r *http.Request, favorite bool) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked)
+	if err != nil {
+		return
+	}
+	image, err := g.GalleryService.Image(gallery.ID, imageKey(r))
+	if err != nil {
+		if errors.Is(err, models.ErrImageNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	user := context.User(r.Context())
+	if favorite {
+		err = g.FavoriteService.FavoriteImage(user.ID, image.ID)
+	} else {
+		err = g.FavoriteService.UnfavoriteImage(user.ID, image.ID)
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	next := safeRedirectPath(r.FormValue("next"), imagePagePath(gallery, image.Key))
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// favoriteImageIDs returns the images of the gallery the current user
+// favorited. Anonymous visitors have no favorites.
+func (g Galleries) favoriteImageIDs(r *http.Request, gallery *models.Gallery) (map[int]bool, error) {
+	user := context.User(r.Context())
+	if user == nil {
+		return map[int]bool{}, nil
+	}
+	return g.FavoriteService.FavoriteImageIDs(user.ID, gallery.ID)
+}
+
+// isFavoriteGallery reports whether the current user favorited the gallery.
+func (g Galleries) isFavoriteGallery(r *http.Request, gallery *models.Gallery) (bool, error) {
+	user := context.User(r.Context())
+	if user == nil {
+		return false, nil
+	}
+	return g.FavoriteService.IsGalleryFavorite(user.ID, gallery.ID)
+}
@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/Shamanskiy/lenslocked/src/markdown"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+// feedSize is the number of galleries in a feed.
+const feedSize = 20
+
+// feed describes a feed of newly published galleries. It is written as Atom
+// or RSS 2.0 depending on the extension of the requested path.
+type feed struct {
+	Title       string
+	Description string
+	// Path is the page the feed belongs to.
+	Path      string
+	Galleries []models.Gallery
+}
+
+// SiteFeedHandler serves the feed of galleries newly published on the site.
+func (g Galleries) SiteFeedHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := g.GalleryService.Explore(models.ExploreNewest, "", feedSize)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	writeFeed(w, r, g.GalleryService, g.BaseURL, feed{
+		Title:       "Lenslocked",
+		Description: "New galleries on Lenslocked",
+		Path:        "/explore",
+		Galleries:   page.Galleries,
+	})
+}
+
+// UserFeedHandler serves the feed of the galleries the user published.
+func (u Users) UserFeedHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := u.profileUser(w, r)
+	if err != nil {
+		return
+	}
+	galleries, err := u.GalleryService.PublishedByUserID(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if len(galleries) > feedSize {
+		galleries = galleries[:feedSize]
+	}
+	writeFeed(w, r, u.GalleryService, u.BaseURL, feed{
+		Title:       user.Name() + " on Lenslocked",
+		Description: "Galleries published by " + user.Name(),
+		Path:        "/u/" + user.Handle,
+		Galleries:   galleries,
+	})
+}
+
+// writeFeed renders the feed and serves it with ETag and Last-Modified
+// headers, answering conditional requests with 304 Not Modified.
+func writeFeed(w http.ResponseWriter, r *http.Request, galleryService *models.GalleryService, baseURL string, f feed) {
+	var modified time.Time
+	entries := make([]feedEntry, 0, len(f.Galleries))
+	for _, gallery := range f.Galleries {
+		entry, err := newFeedEntry(galleryService, baseURL, gallery)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, entry)
+		if entry.Updated.After(modified) {
+			modified = entry.Updated
+		}
+	}
+
+	var document any
+	contentType := "application/atom+xml; charset=utf-8"
+	if path.Ext(r.URL.Path) == ".rss" {
+		document = newRSSFeed(baseURL, f, entries, modified)
+		contentType = "application/rss+xml; charset=utf-8"
+	} else {
+		document = newAtomFeed(baseURL, r.URL.Path, f, entries, modified)
+	}
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	err := xml.NewEncoder(&body).Encode(document)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	hash := sha256.Sum256(body.Bytes())
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+	// ServeContent handles If-None-Match and If-Modified-Since for us
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body.Bytes()))
+}
+
+// feedEntry is a gallery as it is listed in both kinds of feeds.
+type feedEntry struct {
+	// ID identifies the gallery for feed readers. Unlike the URL, it doesn't
+	// change when the gallery is renamed.
+	ID        string
+	Title     string
+	URL       string
+	Author    string
+	Summary   string
+	Published time.Time
+	Updated   time.Time
+	// Cover is nil for galleries without images.
+	Cover *feedEnclosure
+}
+
+type feedEnclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+func newFeedEntry(galleryService *models.GalleryService, baseURL string, gallery models.Gallery) (feedEntry, error) {
+	entry := feedEntry{
+		ID:        fmt.Sprintf("%s/galleries/%d", baseURL, gallery.ID),
+		Title:     gallery.Title,
+		URL:       baseURL + gallery.Path(),
+		Author:    gallery.UserHandle,
+		Summary:   string(markdown.Render(gallery.Description)),
+		Published: gallery.PublishedAt,
+		Updated:   gallery.UpdatedAt,
+	}
+	if gallery.PublishedAt.After(entry.Updated) {
+		entry.Updated = gallery.PublishedAt
+	}
+	if gallery.CoverKey == "" {
+		return entry, nil
+	}
+	cover, err := galleryService.Image(gallery.ID, gallery.CoverKey)
+	if err != nil {
+		return entry, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(cover.Path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	entry.Cover = &feedEnclosure{
+		URL:    fmt.Sprintf("%s/galleries/%d/images/%s", baseURL, gallery.ID, cover.Key),
+		Type:   contentType,
+		Length: cover.Size,
+	}
+	return entry, nil
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomAuthor `xml:"author"`
+	Links     []atomLink `xml:"link"`
+	Summary   atomText   `xml:"summary"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func newAtomFeed(baseURL, feedPath string, f feed, entries []feedEntry, modified time.Time) atomFeed {
+	if modified.IsZero() {
+		// Atom requires a date, and an empty feed hasn't changed in a while
+		modified = time.Unix(0, 0)
+	}
+	atom := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		ID:       baseURL + feedPath,
+		Updated:  modified.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: baseURL + feedPath},
+			{Rel: "alternate", Type: "text/html", Href: baseURL + f.Path},
+		},
+	}
+	for _, entry := range entries {
+		item := atomEntry{
+			Title:     entry.Title,
+			ID:        entry.ID,
+			Published: entry.Published.UTC().Format(time.RFC3339),
+			Updated:   entry.Updated.UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: entry.Author},
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: entry.URL}},
+			Summary:   atomText{Type: "html", Body: entry.Summary},
+		}
+		if entry.Cover != nil {
+			item.Links = append(item.Links, atomLink{
+				Rel:    "enclosure",
+				Type:   entry.Cover.Type,
+				Href:   entry.Cover.URL,
+				Length: entry.Cover.Length,
+			})
+		}
+		atom.Entries = append(atom.Entries, item)
+	}
+	return atom
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func newRSSFeed(baseURL string, f feed, entries []feedEntry, modified time.Time) rssFeed {
+	rss := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        baseURL + f.Path,
+			Description: f.Description,
+		},
+	}
+	if !modified.IsZero() {
+		rss.Channel.LastBuildDate = modified.UTC().Format(time.RFC1123Z)
+	}
+	for _, entry := range entries {
+		item := rssItem{
+			Title:       entry.Title,
+			Link:        entry.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: entry.ID},
+			PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			Description: entry.Summary,
+		}
+		if entry.Cover != nil {
+			item.Enclosure = &rssEnclosure{
+				URL:    entry.Cover.URL,
+				Length: entry.Cover.Length,
+				Type:   entry.Cover.Type,
+			}
+		}
+		rss.Channel.Items = append(rss.Channel.Items, item)
+	}
+	return rss
+}
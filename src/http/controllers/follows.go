@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (u Users) FollowHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	followee, err := u.followee(w, r)
+	if err != nil {
+		return
+	}
+	err = u.FollowService.Follow(user.ID, followee.ID)
+	if err != nil && !errors.Is(err, models.ErrFollowSelf) {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/u/"+followee.Handle, http.StatusFound)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (u Users) UnfollowHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	followee, err := u.followee(w, r)
+	if err != nil {
+		return
+	}
+	err = u.FollowService.Unfollow(user.ID, followee.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/u/"+followee.Handle, http.StatusFound)
+}
+
+// followee looks up the user in the handle URL parameter and renders an
+// error if that fails.
+func (u Users) followee(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	followee, err := u.UserService.ByHandle(chi.URLParam(r, "handle"))
+	if err != nil {
+		if errors.Is(err, models.ErrResourceNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return nil, err
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, err
+	}
+	return followee, nil
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (u Users) UpdateDigestHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	err := u.UserService.SetDigest(user.ID, r.FormValue("digest") == "on")
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/users/me", http.StatusFound)
+}
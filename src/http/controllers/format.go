@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+)
+
+// formatBytes renders a number of bytes in the largest unit that keeps the
+// number above one, e.g. 1.5 MB.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+const dateInputLayout = "2006-01-02"
+
+// formatDateInput formats the date for an <input type="date">. Zero dates
+// are rendered as empty inputs.
+func formatDateInput(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(dateInputLayout)
+}
+
+// parseDateInput parses the value of an <input type="date">. Empty values
+// are parsed as zero dates.
+func parseDateInput(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(dateInputLayout, value)
+}
+
+// formatDateRange formats the dates of a gallery for display, e.g.
+// "Jan 2, 2023 – Jan 5, 2023". Either date can be zero.
+func formatDateRange(start, end time.Time) string {
+	const layout = "Jan 2, 2006"
+	switch {
+	case start.IsZero() && end.IsZero():
+		return ""
+	case end.IsZero() || start.Equal(end):
+		return start.Format(layout)
+	case start.IsZero():
+		return end.Format(layout)
+	default:
+		return start.Format(layout) + " – " + end.Format(layout)
+	}
+}
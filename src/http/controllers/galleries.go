@@ -1,16 +1,18 @@
 package controllers
 
 import (
-	"errors"
 	"fmt"
+	"mime"
 	"net/http"
-	"net/url"
-	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"unicode"
 
+	"github.com/Shamanskiy/lenslocked/src/errors"
 	"github.com/Shamanskiy/lenslocked/src/http/context"
 	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/Shamanskiy/lenslocked/src/ratelimit"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -20,27 +22,83 @@ type Galleries struct {
 		EditGallery    Template
 		IndexGalleries Template
 		ViewGallery    Template
+		ViewImage      Template
+		UnlockGallery  Template
+		// AcceptInvitation asks users to confirm joining a gallery.
+		AcceptInvitation Template
+		AcceptTransfer   Template
+		Trash            Template
+		Tag              Template
+		Explore          Template
+		Favorites        Template
+		Proofing         Template
+		Selections       Template
+		Report           Template
+		// Embed is a standalone page for other sites to show in a frame.
+		Embed Template
 	}
-	GalleryService *models.GalleryService
+	GalleryService   *models.GalleryService
+	ShareLinkService *models.ShareLinkService
+	MemberService    *models.MemberService
+	TransferService  *models.TransferService
+	TagService       *models.TagService
+	CommentService   *models.CommentService
+	FavoriteService  *models.FavoriteService
+	ProofingService  *models.ProofingService
+	ReportService    *models.ReportService
+	AnalyticsService *models.AnalyticsService
+	EmailService     *models.EmailService
+	// BaseURL is the URL the site is reached at, for links that leave the
+	// site.
+	BaseURL string
+	// SigningKey signs the cookies of unlocked galleries.
+	SigningKey []byte
+	// UnlockLimiter limits wrong gallery passwords per gallery and client.
+	UnlockLimiter *ratelimit.Limiter
+	// CommentLimiter limits how many comments each user can write.
+	CommentLimiter *ratelimit.Limiter
+	// ReportLimiter limits how many reports each user can file.
+	ReportLimiter *ratelimit.Limiter
+	// ProofingLimiter limits submitted selections per gallery and client, as
+	// each of them is emailed to the owner.
+	ProofingLimiter *ratelimit.Limiter
 }
 
 const (
-	GALLERY_PUBLIC  = "public"
-	GALLERY_PRIVATE = "private"
+	GALLERY_PUBLIC   = "public"
+	GALLERY_UNLISTED = "unlisted"
+	GALLERY_PRIVATE  = "private"
 )
 
+func galleryVisibility(gallery *models.Gallery) string {
+	switch {
+	case gallery.Published:
+		return GALLERY_PUBLIC
+	case gallery.Unlisted:
+		return GALLERY_UNLISTED
+	default:
+		return GALLERY_PRIVATE
+	}
+}
+
 func (g Galleries) NewGalleryFormHandler(w http.ResponseWriter, r *http.Request) {
 	gallery := models.Gallery{Title: r.FormValue("title")}
-	g.Templates.NewGallery.Execute(w, r, gallery)
+	g.Templates.NewGallery.Execute(w, r, newGalleryFormData(gallery))
 }
 
 func (g Galleries) NewGalleryHandler(w http.ResponseWriter, r *http.Request) {
-	userID := context.User(r.Context()).ID
-	title := r.FormValue("title")
+	details := models.Gallery{
+		UserID: context.User(r.Context()).ID,
+	}
+	err := parseGalleryDetails(r, &details)
+	if err != nil {
+		g.Templates.NewGallery.Execute(w, r, newGalleryFormData(details), err)
+		return
+	}
 
-	gallery, err := g.GalleryService.Create(userID, title)
+	gallery, err := g.GalleryService.Create(details)
 	if err != nil {
-		g.Templates.NewGallery.Execute(w, r, gallery, err)
+		g.Templates.NewGallery.Execute(w, r, newGalleryFormData(details), err)
 		return
 	}
 
@@ -49,51 +107,255 @@ func (g Galleries) NewGalleryHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (g Galleries) EditGalleryFormHandler(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionUpload))
 	if err != nil {
 		return
 	}
+	g.renderEditGallery(w, r, gallery)
+}
 
-	var data struct {
-		ID        int
-		Title     string
-		Published bool
-		Images    []imageData
+type duplicateData struct {
+	Image    imageData
+	Other    imageData
+	Distance int
+}
+
+// permissionsData tells templates which parts of a gallery the current user
+// may change.
+type permissionsData struct {
+	Upload        bool
+	ManageImages  bool
+	EditSettings  bool
+	ManageMembers bool
+	Transfer      bool
+	Delete        bool
+}
+
+func newPermissionsData(role models.Role) permissionsData {
+	return permissionsData{
+		Upload:        role.Can(models.ActionUpload),
+		ManageImages:  role.Can(models.ActionManageImages),
+		EditSettings:  role.Can(models.ActionEditSettings),
+		ManageMembers: role.Can(models.ActionManageMembers),
+		Transfer:      role.Can(models.ActionTransfer),
+		Delete:        role.Can(models.ActionDelete),
 	}
-	data.ID = gallery.ID
-	data.Title = gallery.Title
-	data.Published = gallery.Published
+}
 
-	images, err := g.GalleryService.Images(gallery.ID)
+type editGalleryData struct {
+	galleryFormData
+	ID                 int
+	Can                permissionsData
+	Tags               string
+	Visibility         string
+	DownloadsEnabled   bool
+	Discoverable       bool
+	CommentsEnabled    bool
+	ProofingEnabled    bool
+	ProofingLimit      int
+	PasswordProtected  bool
+	Path               string
+	CoverKey           string
+	Images             []imageData
+	TrashedImages      []trashedImageData
+	PossibleDuplicates []duplicateData
+	FavoriteCount      int
+	MostFavorited      []imageData
+	Views              *viewsData
+	ShareLinks         []shareLinkData
+	Members            []memberData
+	Invitations        []invitationData
+	PendingTransfer    *transferData
+	MemberRoles        []models.Role
+	// NewShareURL is only set right after a share link was created, as it
+	// cannot be shown again later.
+	NewShareURL string
+	// EmbedCode is empty unless other sites may embed the gallery.
+	EmbedCode string
+}
+
+// renderEditGallery shows the edit page of the gallery. Errors are rendered
+// as alerts on top of the page.
+func (g Galleries) renderEditGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, errs ...error) {
+	data, err := g.editGalleryData(r, gallery)
 	if err != nil {
+		fmt.Println(err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	g.Templates.EditGallery.Execute(w, r, data, errs...)
+}
+
+func (g Galleries) editGalleryData(r *http.Request, gallery *models.Gallery) (*editGalleryData, error) {
+	role, err := g.role(r, gallery)
+	if err != nil {
+		return nil, err
+	}
+	data := editGalleryData{
+		galleryFormData:   newGalleryFormData(*gallery),
+		ID:                gallery.ID,
+		Can:               newPermissionsData(role),
+		MemberRoles:       models.MemberRoles,
+		Visibility:        galleryVisibility(gallery),
+		DownloadsEnabled:  gallery.DownloadsEnabled,
+		Discoverable:      gallery.Discoverable,
+		CommentsEnabled:   gallery.CommentsEnabled,
+		ProofingEnabled:   gallery.ProofingEnabled,
+		ProofingLimit:     gallery.ProofingLimit,
+		PasswordProtected: gallery.PasswordProtected(),
+		Path:              gallery.Path(),
+		FavoriteCount:     gallery.FavoriteCount,
+	}
+	if embeddable(gallery) {
+		data.EmbedCode = g.embedCode(gallery, embedWidth, embedHeight)
+	}
+
+	tags, err := g.TagService.GalleryTags(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	data.Tags = joinTags(tags)
+
+	images, err := g.GalleryService.Images(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	imageTags, err := g.TagService.ImageTags(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
 	for _, image := range images {
-		data.Images = append(data.Images, imageData{
-			GalleryID:       gallery.ID,
-			FilenameEscaped: url.PathEscape(image.Filename),
+		entry := newImageData(image)
+		entry.Tags = imageTags[image.ID]
+		data.Images = append(data.Images, entry)
+		if image.ID == gallery.CoverImageID {
+			data.CoverKey = image.Key
+		}
+	}
+
+	if data.Can.ManageImages {
+		trashed, err := g.GalleryService.TrashedImages(gallery.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range trashed {
+			data.TrashedImages = append(data.TrashedImages, trashedImageData{
+				imageData: newImageData(image),
+				DeletedOn: image.DeletedAt.Format("Jan 2, 2006"),
+				PurgesOn:  g.GalleryService.PurgesAt(image.DeletedAt).Format("Jan 2, 2006"),
+			})
+		}
+	}
+
+	duplicates, err := g.GalleryService.PossibleDuplicates(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, duplicate := range duplicates {
+		data.PossibleDuplicates = append(data.PossibleDuplicates, duplicateData{
+			Image:    newImageData(duplicate.Image),
+			Other:    newImageData(duplicate.Other),
+			Distance: duplicate.Distance,
 		})
 	}
 
-	g.Templates.EditGallery.Execute(w, r, data)
+	mostFavorited, err := g.GalleryService.MostFavoritedImages(gallery.ID, mostFavoritedLimit)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range mostFavorited {
+		data.MostFavorited = append(data.MostFavorited, newImageData(image))
+	}
+
+	if data.Can.EditSettings {
+		data.Views, err = g.viewsData(gallery)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if data.Can.EditSettings {
+		shareLinks, err := g.ShareLinkService.ForGallery(gallery.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, shareLink := range shareLinks {
+			data.ShareLinks = append(data.ShareLinks, newShareLinkData(shareLink))
+		}
+	}
+
+	if data.Can.ManageMembers {
+		members, err := g.MemberService.Members(gallery.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			data.Members = append(data.Members, newMemberData(member))
+		}
+		invitations, err := g.MemberService.Invitations(gallery.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, invitation := range invitations {
+			data.Invitations = append(data.Invitations, newInvitationData(invitation))
+		}
+	}
+
+	if data.Can.Transfer {
+		transfer, err := g.TransferService.Pending(gallery.ID)
+		if err != nil {
+			return nil, err
+		}
+		data.PendingTransfer = newTransferData(transfer)
+	}
+
+	return &data, nil
 }
 
 func (g Galleries) EditGalleryHandler(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionEditSettings))
 	if err != nil {
 		return
 	}
 
-	title := r.FormValue("title")
+	err = parseGalleryDetails(r, gallery)
+	if err != nil {
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
 	visibility := r.FormValue("visibility")
-	gallery.Title = title
 	gallery.Published = visibility == GALLERY_PUBLIC
+	gallery.Unlisted = visibility == GALLERY_UNLISTED
+	gallery.DownloadsEnabled = r.FormValue("downloads") == "on"
+	gallery.Discoverable = r.FormValue("discoverable") == "on"
+	gallery.CommentsEnabled = r.FormValue("comments") == "on"
+	gallery.ProofingEnabled = r.FormValue("proofing") == "on"
+	gallery.ProofingLimit, err = parseProofingLimit(r.FormValue("proofing_limit"))
+	if err != nil {
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+
+	gallery.CoverImageID = 0
+	coverKey := r.FormValue("cover")
+	if coverKey != "" {
+		cover, err := g.GalleryService.Image(gallery.ID, coverKey)
+		if err == nil {
+			gallery.CoverImageID = cover.ID
+		}
+	}
+
 	err = g.GalleryService.Update(gallery)
 	if err != nil {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	err = g.TagService.SetGalleryTags(gallery.ID, models.ParseTags(r.FormValue("tags")))
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
@@ -109,43 +371,273 @@ func (g Galleries) IndexGalleriesHandler(w http.ResponseWriter, r *http.Request)
 	sort.Slice(galleries, func(a, b int) bool {
 		return galleries[a].ID < galleries[b].ID
 	})
+	shared, err := g.MemberService.SharedGalleries(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
 	var data struct {
 		Galleries []models.Gallery
+		Shared    []sharedGalleryData
 	}
 	data.Galleries = galleries
+	for _, gallery := range shared {
+		data.Shared = append(data.Shared, sharedGalleryData{
+			Gallery: gallery.Gallery,
+			Role:    gallery.Role,
+			Can:     newPermissionsData(gallery.Role),
+		})
+	}
 
 	g.Templates.IndexGalleries.Execute(w, r, data)
 }
 
+// RedirectGalleryHandler sends requests for the old /galleries/{id} URLs to
+// the public URL of the gallery.
+func (g Galleries) RedirectGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery)
+	if err != nil {
+		return
+	}
+	http.Redirect(w, r, withQuery(gallery.Path(), r), http.StatusMovedPermanently)
+}
+
 func (g Galleries) ViewGalleryHandler(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnPrivateGallery)
+	gallery, err := g.galleryBySlug(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked)
 	if err != nil {
 		return
 	}
 
-	var data struct {
-		Title  string
-		Images []imageData
+	g.recordShareLinkView(r, gallery)
+	g.recordView(r, gallery)
+	g.renderViewGallery(w, r, gallery)
+}
+
+type viewGalleryData struct {
+	ID          int
+	Path        string
+	OwnerHandle string
+	Title       string
+	Description string
+	Location    string
+	DateRange   string
+	CanDownload bool
+	SignedIn    bool
+	// ProofingPath links to the proofing page if proofing is enabled.
+	ProofingPath string
+	// FavoriteCount and Favorite describe the favorites of the gallery, as
+	// opposed to the ones of its images.
+	FavoriteCount int
+	Favorite      bool
+	Tags          []string
+	Images        []imageData
+	Comments      commentsData
+	// OEmbedURL lets other sites discover how to embed the gallery. It is
+	// empty unless the gallery is embeddable.
+	OEmbedURL string
+}
+
+// renderViewGallery shows the public page of the gallery. Errors are
+// rendered as alerts on top of the page.
+func (g Galleries) renderViewGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, errs ...error) {
+	data, err := g.viewGalleryData(r, gallery)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
 	}
-	data.Title = gallery.Title
+	g.Templates.ViewGallery.Execute(w, r, data, errs...)
+}
 
+func (g Galleries) viewGalleryData(r *http.Request, gallery *models.Gallery) (*viewGalleryData, error) {
+	data := viewGalleryData{
+		ID:            gallery.ID,
+		Path:          gallery.Path(),
+		OwnerHandle:   gallery.UserHandle,
+		Title:         gallery.Title,
+		Description:   gallery.Description,
+		Location:      gallery.Location,
+		DateRange:     formatDateRange(gallery.StartsOn, gallery.EndsOn),
+		CanDownload:   g.canDownload(r, gallery),
+		SignedIn:      context.User(r.Context()) != nil,
+		FavoriteCount: gallery.FavoriteCount,
+	}
+	if embeddable(gallery) {
+		data.OEmbedURL = g.oEmbedURL(gallery)
+	}
+
+	var err error
+	data.Favorite, err = g.isFavoriteGallery(r, gallery)
+	if err != nil {
+		return nil, err
+	}
+	if gallery.ProofingEnabled {
+		data.ProofingPath = proofingPath(gallery)
+	}
+	data.Tags, err = g.TagService.GalleryTags(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
 	images, err := g.GalleryService.Images(gallery.ID)
 	if err != nil {
+		return nil, err
+	}
+	imageTags, err := g.TagService.ImageTags(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	commentCounts, err := g.CommentService.ImageCommentCounts(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	favorites, err := g.favoriteImageIDs(r, gallery)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		entry := newImageData(image)
+		entry.Tags = imageTags[image.ID]
+		entry.CommentCount = commentCounts[image.ID]
+		entry.Favorite = favorites[image.ID]
+		data.Images = append(data.Images, entry)
+	}
+
+	data.Comments, err = g.commentsData(r, gallery, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// ViewImageHandler shows a single image of the gallery together with the
+// comments on it.
+func (g Galleries) ViewImageHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryBySlug(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked)
+	if err != nil {
+		return
+	}
+	image, err := g.GalleryService.Image(gallery.ID, imageKey(r))
+	if err != nil {
+		if errors.Is(err, models.ErrImageNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		fmt.Println(err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
-	for _, img := range images {
-		data.Images = append(data.Images, imageData{
-			GalleryID:       gallery.ID,
-			FilenameEscaped: url.PathEscape(img.Filename),
-		})
+
+	g.recordShareLinkView(r, gallery)
+	g.recordImageView(r, gallery, image)
+	g.renderViewImage(w, r, gallery, image)
+}
+
+type viewImageData struct {
+	GalleryID    int
+	GalleryPath  string
+	GalleryTitle string
+	OwnerHandle  string
+	SignedIn     bool
+	Image        imageData
+	// PrevPath and NextPath link to the neighbouring images, or are empty
+	// at the ends of the gallery.
+	PrevPath string
+	NextPath string
+	Comments commentsData
+}
+
+// renderViewImage shows the page of an image. Errors are rendered as alerts
+// on top of the page.
+func (g Galleries) renderViewImage(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, image models.Image, errs ...error) {
+	data, err := g.viewImageData(r, gallery, image)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	g.Templates.ViewImage.Execute(w, r, data, errs...)
+}
+
+func (g Galleries) viewImageData(r *http.Request, gallery *models.Gallery, image models.Image) (*viewImageData, error) {
+	data := viewImageData{
+		GalleryID:    gallery.ID,
+		GalleryPath:  gallery.Path(),
+		GalleryTitle: gallery.Title,
+		OwnerHandle:  gallery.UserHandle,
+		SignedIn:     context.User(r.Context()) != nil,
+		Image:        newImageData(image),
+	}
+
+	imageTags, err := g.TagService.ImageTags(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	data.Image.Tags = imageTags[image.ID]
+	favorites, err := g.favoriteImageIDs(r, gallery)
+	if err != nil {
+		return nil, err
 	}
+	data.Image.Favorite = favorites[image.ID]
 
-	g.Templates.ViewGallery.Execute(w, r, data)
+	images, err := g.GalleryService.Images(gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i, other := range images {
+		if other.ID != image.ID {
+			continue
+		}
+		if i > 0 {
+			data.PrevPath = imagePagePath(gallery, images[i-1].Key)
+		}
+		if i < len(images)-1 {
+			data.NextPath = imagePagePath(gallery, images[i+1].Key)
+		}
+	}
+
+	data.Comments, err = g.commentsData(r, gallery, &image)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// imagePagePath returns the path of the page showing the image, as opposed
+// to the path serving the image file.
+func imagePagePath(gallery *models.Gallery, key string) string {
+	return gallery.Path() + "/images/" + key
+}
+
+// recordView counts the view for the analytics of the owner and the explore
+// page unless the owner is looking at their own gallery. Like the analytics,
+// the explore page only counts each visitor once a day, so reloading a
+// gallery doesn't push it up.
+func (g Galleries) recordView(r *http.Request, gallery *models.Gallery) {
+	user := context.User(r.Context())
+	if user != nil && user.ID == gallery.UserID {
+		return
+	}
+	if !countsAsVisit(r, gallery) {
+		return
+	}
+	counted, err := g.AnalyticsService.RecordGalleryView(gallery.ID, visitor(r))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !counted {
+		return
+	}
+	err = g.GalleryService.RecordView(gallery.ID)
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
 func (g Galleries) DeleteGalleryHandler(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionDelete))
 	if err != nil {
 		return
 	}
@@ -157,14 +649,74 @@ func (g Galleries) DeleteGalleryHandler(w http.ResponseWriter, r *http.Request)
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
-func (g Galleries) ImageHandler(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnPrivateGallery)
+// CloneGalleryHandler creates a new gallery of the current user with the
+// details and images of the gallery. If some images are selected, only those
+// are copied.
+func (g Galleries) CloneGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionEditSettings))
 	if err != nil {
 		return
 	}
-	filename := g.filename(r)
+	err = r.ParseForm()
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	keys := r.PostForm["clone-images"]
+	title := strings.TrimSpace(r.FormValue("clone-title"))
+	if title == "" {
+		title = gallery.Title + " (copy)"
+	}
 
-	image, err := g.GalleryService.Image(gallery.ID, filename)
+	images, err := g.GalleryService.Images(gallery.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if len(keys) > 0 {
+		images = selectImages(images, keys)
+	}
+	user := context.User(r.Context())
+	clone, err := g.GalleryService.Clone(*gallery, user.ID, title, images)
+	if err != nil {
+		if errors.Is(err, models.ErrQuotaExceeded) {
+			err = errors.Public(err, "The copied images would exceed your storage quota. Select fewer images or ask an admin for more space.")
+		}
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", clone.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// selectImages returns the images with the given keys, keeping their order.
+func selectImages(images []models.Image, keys []string) []models.Image {
+	selected := map[string]bool{}
+	for _, key := range keys {
+		selected[key] = true
+	}
+	var result []models.Image
+	for _, image := range images {
+		if selected[image.Key] {
+			result = append(result, image)
+		}
+	}
+	return result
+}
+
+func (g Galleries) ImageHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked)
+	if err != nil {
+		return
+	}
+	image, err := g.GalleryService.Image(gallery.ID, imageKey(r))
+	if errors.Is(err, models.ErrImageNotFound) {
+		// images in the trash are only shown to those who can restore them
+		role, roleErr := g.role(r, gallery)
+		if roleErr == nil && role.Can(models.ActionManageImages) {
+			image, err = g.GalleryService.TrashedImage(gallery.ID, imageKey(r))
+		}
+	}
 	if err != nil {
 		if errors.Is(err, models.ErrImageNotFound) {
 			http.Error(w, "Image not found", http.StatusNotFound)
@@ -177,23 +729,95 @@ func (g Galleries) ImageHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, image.Path)
 }
 
+func (g Galleries) DownloadGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery,
+		g.galleryMustBeUnlocked, g.downloadsMustBeEnabled)
+	if err != nil {
+		return
+	}
+	withManifest := r.FormValue("manifest") == "true"
+
+	archiveName := archiveFilename(gallery.Title)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": archiveName}))
+
+	// The archive is streamed straight into the response, so once writing has
+	// started we can no longer report an error to the client.
+	err = g.GalleryService.WriteArchive(w, *gallery, withManifest)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+}
+
 func (g Galleries) DeleteImageHandler(w http.ResponseWriter, r *http.Request) {
-	filename := g.filename(r)
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageImages))
+	if err != nil {
+		return
+	}
+	err = g.GalleryService.DeleteImage(gallery.ID, imageKey(r))
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// UpdateImagesHandler saves the order, captions, alt texts and tags of all
+// images in the gallery. The order comes from the position inputs, which are either
+// filled in by hand or rewritten by drag-and-drop on the edit page.
+func (g Galleries) UpdateImagesHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageImages))
+	if err != nil {
+		return
+	}
+
+	images, err := g.GalleryService.Images(gallery.ID)
 	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
-	err = g.GalleryService.DeleteImage(gallery.ID, filename)
+	for i := range images {
+		image := &images[i]
+		position, err := strconv.Atoi(r.FormValue("position-" + image.Key))
+		if err == nil {
+			image.Position = position
+		}
+		image.Caption = strings.TrimSpace(r.FormValue("caption-" + image.Key))
+		image.AltText = strings.TrimSpace(r.FormValue("alt-" + image.Key))
+	}
+
+	// positions entered by hand may have gaps or duplicates, so they are only
+	// used for sorting and then renumbered
+	sort.SliceStable(images, func(a, b int) bool {
+		return images[a].Position < images[b].Position
+	})
+	for i := range images {
+		images[i].Position = i + 1
+	}
+
+	err = g.GalleryService.UpdateImages(gallery.ID, images)
 	if err != nil {
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	for _, image := range images {
+		tags := models.ParseTags(r.FormValue("tags-" + image.Key))
+		err = g.TagService.SetImageTags(image.ID, tags)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
 
 func (g Galleries) UploadImageHandler(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionUpload))
 	if err != nil {
 		return
 	}
@@ -204,6 +828,7 @@ func (g Galleries) UploadImageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var skipped []string
 	fileHeaders := r.MultipartForm.File["images"]
 	for _, fileHeader := range fileHeaders {
 		file, err := fileHeader.Open()
@@ -215,12 +840,24 @@ func (g Galleries) UploadImageHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("Attempting to upload %v for gallery %d.\n",
 			fileHeader.Filename, gallery.ID)
 
-		err = g.GalleryService.CreateImage(gallery.ID, fileHeader.Filename, file)
+		_, err = g.GalleryService.CreateImage(gallery.ID, fileHeader.Filename, file)
 		if err != nil {
+			if errors.Is(err, models.ErrDuplicateImage) {
+				skipped = append(skipped, fileHeader.Filename)
+				continue
+			}
+			if errors.Is(err, models.ErrQuotaExceeded) {
+				msg := fmt.Sprintf("Uploading %v would exceed your storage quota. Delete some images or ask an admin for more space.", fileHeader.Filename)
+				if context.User(r.Context()).ID != gallery.UserID {
+					msg = fmt.Sprintf("Uploading %v would exceed the storage quota of the gallery owner.", fileHeader.Filename)
+				}
+				g.renderEditGallery(w, r, gallery, errors.Public(err, msg))
+				return
+			}
 			var fileErr models.FileError
 			if errors.As(err, &fileErr) {
 				msg := fmt.Sprintf("%v has an invalid content type or extension. Only png, gif, and jpg files can be uploaded.", fileHeader.Filename)
-				http.Error(w, msg, http.StatusBadRequest)
+				g.renderEditGallery(w, r, gallery, errors.Public(err, msg))
 				return
 			}
 			fmt.Println(err)
@@ -229,6 +866,13 @@ func (g Galleries) UploadImageHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if len(skipped) > 0 {
+		msg := fmt.Sprintf("Skipped %v: the same images are already in this gallery.",
+			strings.Join(skipped, ", "))
+		g.renderEditGallery(w, r, gallery, errors.Public(models.ErrDuplicateImage, msg))
+		return
+	}
+
 	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
@@ -242,6 +886,34 @@ func (g Galleries) galleryByID(w http.ResponseWriter, r *http.Request, opts ...g
 		return nil, err
 	}
 	gallery, err := g.GalleryService.FindByID(id)
+	return g.checkGallery(w, r, gallery, err, opts...)
+}
+
+// galleryBySlug looks up the gallery by the handle and slug in the URL. If the
+// slug belongs to an old title of the gallery, or the gallery has been
+// transferred to another owner since, the visitor is redirected to the
+// current URL and an error is returned.
+func (g Galleries) galleryBySlug(w http.ResponseWriter, r *http.Request, opts ...galleryOpt) (*models.Gallery, error) {
+	handle := chi.URLParam(r, "handle")
+	slug := chi.URLParam(r, "slug")
+	gallery, err := g.GalleryService.FindBySlug(handle, slug)
+	gallery, err = g.checkGallery(w, r, gallery, err, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if gallery.Slug != slug || gallery.UserHandle != handle {
+		// pages below the gallery, like image pages, keep the rest of the URL
+		oldPath := "/u/" + handle + "/" + slug
+		path := gallery.Path() + strings.TrimPrefix(r.URL.Path, oldPath)
+		http.Redirect(w, r, withQuery(path, r), http.StatusMovedPermanently)
+		return nil, fmt.Errorf("gallery has moved to %v", path)
+	}
+	return gallery, nil
+}
+
+// checkGallery handles the result of a gallery lookup and applies the
+// options to the gallery that was found.
+func (g Galleries) checkGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, err error, opts ...galleryOpt) (*models.Gallery, error) {
 	if err != nil {
 		if errors.Is(err, models.ErrResourceNotFound) {
 			http.Error(w, "Gallery not found", http.StatusNotFound)
@@ -261,36 +933,192 @@ func (g Galleries) galleryByID(w http.ResponseWriter, r *http.Request, opts ...g
 	return gallery, nil
 }
 
-func userMustOwnGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
-	user := context.User(r.Context())
-	if user.ID != gallery.UserID {
-		http.Error(w, "You are not authorized to edit this gallery", http.StatusForbidden)
-		return fmt.Errorf("user does not have access to this gallery")
+// userCan only lets users through whose role in the gallery allows the
+// action.
+func (g Galleries) userCan(action models.Action) galleryOpt {
+	return func(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+		role, err := g.role(r, gallery)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return err
+		}
+		if !role.Can(action) {
+			http.Error(w, "You are not authorized to do this", http.StatusForbidden)
+			return fmt.Errorf("user is not allowed to do this with the gallery")
+		}
+		return nil
 	}
-	return nil
 }
 
-func userMustOwnPrivateGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
-	if gallery.Published {
+// role returns the role of the current user in the gallery.
+func (g Galleries) role(r *http.Request, gallery *models.Gallery) (models.Role, error) {
+	return g.MemberService.RoleOf(context.User(r.Context()), gallery)
+}
+
+// userCanViewGallery lets everyone view published galleries. Unlisted
+// galleries can also be viewed with a valid share link, and private ones
+// only by their owner and members.
+func (g Galleries) userCanViewGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if gallery.Published && !gallery.OwnerSuspended {
 		return nil
 	}
 
-	user := context.User(r.Context())
-	if user == nil || user.ID != gallery.UserID {
-		http.Error(w, "You are not authorized to view this gallery", http.StatusForbidden)
-		return fmt.Errorf("user does not have access to this gallery")
+	role, err := g.role(r, gallery)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return err
+	}
+	if role.Can(models.ActionView) {
+		return nil
+	}
+
+	if gallery.Unlisted && g.validShareLink(w, r, gallery) != nil {
+		return nil
 	}
 
+	http.Error(w, "You are not authorized to view this gallery", http.StatusForbidden)
+	return fmt.Errorf("user does not have access to this gallery")
+}
+
+// withQuery appends the query of the request to the path, so redirects keep
+// parameters like share tokens.
+func withQuery(path string, r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return path
+	}
+	return path + "?" + r.URL.RawQuery
+}
+
+func (g Galleries) downloadsMustBeEnabled(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if !g.canDownload(r, gallery) {
+		http.Error(w, "Downloads are disabled for this gallery", http.StatusForbidden)
+		return fmt.Errorf("downloads are disabled for this gallery")
+	}
 	return nil
 }
 
-func (g Galleries) filename(r *http.Request) string {
-	filename := chi.URLParam(r, "filename")
-	filename = filepath.Base(filename)
-	return filename
+// canDownload reports whether the current user can download the gallery.
+// Members can always download, visitors only if downloads are enabled.
+func (g Galleries) canDownload(r *http.Request, gallery *models.Gallery) bool {
+	if gallery.DownloadsEnabled {
+		return true
+	}
+	role, err := g.role(r, gallery)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return role.Can(models.ActionView)
+}
+
+// archiveFilename turns the gallery title into a filename that is safe to use
+// in the Content-Disposition header and on any file system.
+func archiveFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			return r
+		case unicode.IsSpace(r):
+			return '_'
+		default:
+			return -1
+		}
+	}, title)
+	if name == "" {
+		name = "gallery"
+	}
+	return name + ".zip"
+}
+
+func imageKey(r *http.Request) string {
+	return chi.URLParam(r, "key")
+}
+
+// galleryFormData holds the gallery details shared by the new and edit
+// gallery forms. Dates are formatted for date inputs.
+type galleryFormData struct {
+	Title       string
+	Description string
+	Location    string
+	StartsOn    string
+	EndsOn      string
+}
+
+func newGalleryFormData(gallery models.Gallery) galleryFormData {
+	return galleryFormData{
+		Title:       gallery.Title,
+		Description: gallery.Description,
+		Location:    gallery.Location,
+		StartsOn:    formatDateInput(gallery.StartsOn),
+		EndsOn:      formatDateInput(gallery.EndsOn),
+	}
+}
+
+// parseGalleryDetails reads the details shared by the new and edit gallery
+// forms into the gallery. The gallery is updated even if some of the values
+// are invalid, so the form can be rendered again with what the user entered.
+func parseGalleryDetails(r *http.Request, gallery *models.Gallery) error {
+	gallery.Title = strings.TrimSpace(r.FormValue("title"))
+	gallery.Description = strings.TrimSpace(r.FormValue("description"))
+	gallery.Location = strings.TrimSpace(r.FormValue("location"))
+
+	var err error
+	gallery.StartsOn, err = parseDateInput(r.FormValue("starts_on"))
+	if err != nil {
+		return errors.Public(err, "The start date is not a valid date.")
+	}
+	gallery.EndsOn, err = parseDateInput(r.FormValue("ends_on"))
+	if err != nil {
+		return errors.Public(err, "The end date is not a valid date.")
+	}
+	if !gallery.StartsOn.IsZero() && !gallery.EndsOn.IsZero() &&
+		gallery.EndsOn.Before(gallery.StartsOn) {
+		return errors.Public(fmt.Errorf("gallery ends before it starts"),
+			"The end date must not be before the start date.")
+	}
+	return nil
 }
 
 type imageData struct {
-	GalleryID       int
-	FilenameEscaped string
+	GalleryID int
+	Key       string
+	Filename  string
+	Position  int
+	Caption   string
+	AltText   string
+	Tags      []string
+	// CommentCount is only set on the gallery page.
+	CommentCount  int
+	FavoriteCount int
+	// Favorite tells if the current user favorited the image. It is only
+	// set on the gallery and image pages.
+	Favorite bool
+}
+
+func newImageData(image models.Image) imageData {
+	return imageData{
+		GalleryID:     image.GalleryID,
+		Key:           image.Key,
+		Filename:      image.Filename,
+		Position:      image.Position,
+		Caption:       image.Caption,
+		AltText:       image.AltText,
+		FavoriteCount: image.FavoriteCount,
+	}
+}
+
+// Alt returns the text for the alt attribute of the image. Owners don't
+// always describe their images, so the filename is used as a fallback.
+func (data imageData) Alt() string {
+	if data.AltText != "" {
+		return data.AltText
+	}
+	return data.Filename
+}
+
+// TagList returns the tags of the image for the comma separated tag input.
+func (data imageData) TagList() string {
+	return joinTags(data.Tags)
 }
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/cookie"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+// unlockMaxAge is how long a visitor can view a password protected gallery
+// before having to enter the password again.
+const unlockMaxAge = 24 * time.Hour
+
+type unlockGalleryData struct {
+	ID    int
+	Title string
+	// Next is the page the visitor is sent to after unlocking the gallery.
+	Next string
+}
+
+// galleryMustBeUnlocked shows the unlock form to visitors of a password
+// protected gallery unless they have entered the password before. Owners and
+// members never have to enter the password.
+func (g Galleries) galleryMustBeUnlocked(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if !gallery.PasswordProtected() {
+		return nil
+	}
+
+	role, err := g.role(r, gallery)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return err
+	}
+	if role.Can(models.ActionView) {
+		return nil
+	}
+
+	value, err := cookie.ReadSigned(r, unlockCookieName(gallery), g.SigningKey)
+	if err == nil && value == unlockCookieValue(gallery) {
+		return nil
+	}
+
+	g.Templates.UnlockGallery.Execute(w, r, unlockGalleryData{
+		ID:    gallery.ID,
+		Title: gallery.Title,
+		Next:  r.URL.RequestURI(),
+	})
+	return fmt.Errorf("gallery is locked")
+}
+
+// UnlockGalleryHandler checks the gallery password and remembers a correct
+// one in a signed cookie. Attempts are limited per gallery and client to
+// make guessing passwords impractical, and a correct password resets them.
+func (g Galleries) UnlockGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery)
+	if err != nil {
+		return
+	}
+	data := unlockGalleryData{
+		ID:    gallery.ID,
+		Title: gallery.Title,
+		Next:  safeRedirectPath(r.FormValue("next"), gallery.Path()),
+	}
+
+	// every attempt is counted up front, so parallel attempts can't all pass
+	// the limit while their passwords are being checked
+	limitKey := strconv.Itoa(gallery.ID) + "|" + clientIP(r)
+	if !g.UnlockLimiter.Hit(limitKey) {
+		minutes := math.Ceil(g.UnlockLimiter.RetryAfter(limitKey).Minutes())
+		err = errors.Public(fmt.Errorf("too many unlock attempts"),
+			fmt.Sprintf("Too many wrong passwords. Please try again in %.0f minutes.", minutes))
+		g.Templates.UnlockGallery.Execute(w, r, data, err)
+		return
+	}
+
+	err = g.GalleryService.CheckPassword(*gallery, r.FormValue("password"))
+	if err != nil {
+		if errors.Is(err, models.ErrPasswordWrong) {
+			err = errors.Public(err, "The password is wrong.")
+		}
+		g.Templates.UnlockGallery.Execute(w, r, data, err)
+		return
+	}
+
+	g.UnlockLimiter.Reset(limitKey)
+	cookie.SetSigned(w, unlockCookieName(gallery), unlockCookieValue(gallery),
+		g.SigningKey, unlockMaxAge)
+	http.Redirect(w, r, data.Next, http.StatusFound)
+}
+
+// SetPasswordHandler sets, changes or removes the gallery password.
+func (g Galleries) SetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionEditSettings))
+	if err != nil {
+		return
+	}
+
+	password := ""
+	if r.FormValue("remove") != "on" {
+		password = r.FormValue("password")
+		if password == "" {
+			err = errors.Public(fmt.Errorf("empty gallery password"),
+				"Enter a password or choose to remove it.")
+			g.renderEditGallery(w, r, gallery, err)
+			return
+		}
+	}
+
+	err = g.GalleryService.SetPassword(gallery.ID, password)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func unlockCookieName(gallery *models.Gallery) string {
+	return cookie.CookieUnlock + strconv.Itoa(gallery.ID)
+}
+
+// unlockCookieValue is derived from the password hash, so changing the
+// password locks out everyone who unlocked the gallery before.
+func unlockCookieValue(gallery *models.Gallery) string {
+	hash := sha256.Sum256([]byte(gallery.PasswordHash))
+	return hex.EncodeToString(hash[:])
+}
+
+// safeRedirectPath returns path if it is a local path, and fallback
+// otherwise, so that forms can't be used to redirect to other sites.
+func safeRedirectPath(path, fallback string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") ||
+		strings.HasPrefix(path, "/\\") {
+		return fallback
+	}
+	return path
+}
+
+// clientIP returns the IP address the request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+const (
+	// timelinePeriod is how far back the timeline on the home page goes.
+	timelinePeriod = 30 * 24 * time.Hour
+	// timelineLimit is the number of entries shown on the home page.
+	timelineLimit = 30
+)
+
+type timelineEntryData struct {
+	Gallery       models.Gallery
+	NewImageKeys  []string
+	NewImageCount int
+	At            string
+}
+
+// Home shows the timeline of the people a signed in user follows, and the
+// static home page to everyone else.
+func Home(template Template, followService *models.FollowService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data struct {
+			SignedIn bool
+			Timeline []timelineEntryData
+		}
+		user := context.User(r.Context())
+		if user == nil {
+			template.Execute(w, r, data)
+			return
+		}
+
+		data.SignedIn = true
+		entries, err := followService.Timeline(user.ID, time.Now().Add(-timelinePeriod), timelineLimit)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		for _, entry := range entries {
+			data.Timeline = append(data.Timeline, timelineEntryData{
+				Gallery:       entry.Gallery,
+				NewImageKeys:  entry.NewImageKeys,
+				NewImageCount: entry.NewImageCount,
+				At:            entry.At.Format("Jan 2, 2006"),
+			})
+		}
+		template.Execute(w, r, data)
+	}
+}
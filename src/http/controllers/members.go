@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type memberData struct {
+	UserID int
+	Email  string
+	Handle string
+	Role   models.Role
+}
+
+func newMemberData(member models.Member) memberData {
+	return memberData{
+		UserID: member.UserID,
+		Email:  member.Email,
+		Handle: member.Handle,
+		Role:   member.Role,
+	}
+}
+
+type invitationData struct {
+	ID        int
+	Email     string
+	Role      models.Role
+	ExpiresAt string
+}
+
+func newInvitationData(invitation models.Invitation) invitationData {
+	return invitationData{
+		ID:        invitation.ID,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		ExpiresAt: invitation.ExpiresAt.Format("Jan 2, 2006"),
+	}
+}
+
+// sharedGalleryData is a gallery of another user listed on the index page.
+type sharedGalleryData struct {
+	models.Gallery
+	Role models.Role
+	Can  permissionsData
+}
+
+// InviteMemberHandler emails an invitation to join the gallery with the
+// chosen role.
+func (g Galleries) InviteMemberHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageMembers))
+	if err != nil {
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !strings.Contains(email, "@") {
+		err = errors.Public(fmt.Errorf("invalid email %q", email),
+			"Please enter a valid email address.")
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+
+	user := context.User(r.Context())
+	invitation, err := g.MemberService.Invite(gallery.ID, email,
+		models.Role(r.FormValue("role")), user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidRole) {
+			err = errors.Public(err, "Please choose a valid role.")
+		}
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+
+	vals := url.Values{
+		"token": {invitation.Token},
+	}
+	err = g.EmailService.GalleryInvitation(invitation.Email, user.Handle, gallery.Title,
+		g.BaseURL+"/invitations/accept?"+vals.Encode())
+	if err != nil {
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) DeleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageMembers))
+	if err != nil {
+		return
+	}
+	invitationID, err := strconv.Atoi(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	err = g.MemberService.DeleteInvitation(gallery.ID, invitationID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) UpdateMemberHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageMembers))
+	if err != nil {
+		return
+	}
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	err = g.MemberService.SetRole(gallery.ID, userID, models.Role(r.FormValue("role")))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidRole) {
+			err = errors.Public(err, "Please choose a valid role.")
+		}
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageMembers))
+	if err != nil {
+		return
+	}
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	err = g.MemberService.Remove(gallery.ID, userID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+type acceptInvitationData struct {
+	Token        string
+	GalleryTitle string
+	Role         models.Role
+}
+
+// AcceptInvitationFormHandler asks the signed in user to confirm joining the
+// gallery from an invitation link.
+func (g Galleries) AcceptInvitationFormHandler(w http.ResponseWriter, r *http.Request) {
+	data := acceptInvitationData{
+		Token: r.FormValue("token"),
+	}
+	invitation, err := g.MemberService.FindInvitation(data.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidInvitation) {
+			err = errors.Public(err, "This invitation is invalid or has expired. Please ask for a new one.")
+		}
+		g.Templates.AcceptInvitation.Execute(w, r, data, err)
+		return
+	}
+	if !invitation.IsFor(context.User(r.Context()).Email) {
+		g.Templates.AcceptInvitation.Execute(w, r, data, invitationForOtherError())
+		return
+	}
+	data.Role = invitation.Role
+
+	gallery, err := g.GalleryService.FindByID(invitation.GalleryID)
+	if err != nil {
+		g.Templates.AcceptInvitation.Execute(w, r, data, err)
+		return
+	}
+	data.GalleryTitle = gallery.Title
+
+	g.Templates.AcceptInvitation.Execute(w, r, data)
+}
+
+func invitationForOtherError() error {
+	return errors.Public(models.ErrInvitationForOther,
+		"This invitation was sent to another email address. Please sign in with the account of that address to accept it.")
+}
+
+func (g Galleries) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	data := acceptInvitationData{
+		Token: r.FormValue("token"),
+	}
+	user := context.User(r.Context())
+	invitation, err := g.MemberService.AcceptInvitation(data.Token, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidInvitation):
+			err = errors.Public(err, "This invitation is invalid or has expired. Please ask for a new one.")
+		case errors.Is(err, models.ErrInvitationForOther):
+			err = invitationForOtherError()
+		}
+		g.Templates.AcceptInvitation.Execute(w, r, data, err)
+		return
+	}
+
+	gallery, err := g.GalleryService.FindByID(invitation.GalleryID)
+	if err != nil {
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	if invitation.Role.Can(models.ActionUpload) {
+		editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+		http.Redirect(w, r, editPath, http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, gallery.Path(), http.StatusFound)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type queuedReportData struct {
+	ID          int
+	Content     string
+	GalleryPath string
+	// ImagePath and ImageURL are empty unless the report names an image.
+	ImagePath      string
+	ImageURL       string
+	CommentBody    string
+	OwnerHandle    string
+	ReporterHandle string
+	Reason         string
+	Details        string
+	CreatedAt      string
+	// CanUnpublish is false for reports about comments, which can't be
+	// unpublished.
+	CanUnpublish bool
+}
+
+func newQueuedReportData(report models.Report) queuedReportData {
+	data := queuedReportData{
+		ID:             report.ID,
+		Content:        report.Content(),
+		GalleryPath:    report.GalleryPath,
+		CommentBody:    report.CommentBody,
+		OwnerHandle:    report.OwnerHandle,
+		ReporterHandle: report.ReporterHandle,
+		Reason:         report.Reason.Label(),
+		Details:        report.Details,
+		CreatedAt:      report.CreatedAt.Format("Jan 2, 2006 15:04"),
+		CanUnpublish:   report.CommentID == 0,
+	}
+	if report.ImageKey != "" {
+		data.ImagePath = report.GalleryPath + "/images/" + report.ImageKey
+		data.ImageURL = fmt.Sprintf("/galleries/%d/images/%s", report.GalleryID, report.ImageKey)
+	}
+	return data
+}
+
+// ReportsHandler shows the moderation queue with the open reports and the
+// suspended users.
+//
+// This handler expects to sit behind userMiddleware.RequireAdmin
+func (a Admin) ReportsHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderReports(w, r)
+}
+
+func (a Admin) renderReports(w http.ResponseWriter, r *http.Request, errs ...error) {
+	reports, err := a.ReportService.Open()
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	suspended, err := a.UserService.Suspended()
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		Reports   []queuedReportData
+		Suspended []models.User
+	}
+	for _, report := range reports {
+		data.Reports = append(data.Reports, newQueuedReportData(report))
+	}
+	data.Suspended = suspended
+	a.Templates.Reports.Execute(w, r, data, errs...)
+}
+
+// ResolveReportHandler closes a report with the action the admin picked and
+// notifies the reporters and the owner of the content.
+//
+// This handler expects to sit behind userMiddleware.RequireAdmin
+func (a Admin) ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+	admin := context.User(r.Context())
+	resolution := models.ReportResolution(r.FormValue("resolution"))
+	resolved, err := a.ReportService.Resolve(id, admin.ID, resolution)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrReportNotFound):
+			err = errors.Public(err, "The report has been resolved already.")
+		case errors.Is(err, models.ErrInvalidReport):
+			err = errors.Public(err, "This action is not available for the report.")
+		default:
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		a.renderReports(w, r, err)
+		return
+	}
+	a.notifyResolved(resolved, resolution)
+	http.Redirect(w, r, "/admin/reports", http.StatusFound)
+}
+
+// notifyResolved emails the reporters of the resolved reports, and the owner
+// of the content unless the report was dismissed. The reports are resolved
+// already, so failed emails are only logged.
+func (a Admin) notifyResolved(resolved []models.Report, resolution models.ReportResolution) {
+	for _, report := range resolved {
+		if report.ReporterEmail == "" {
+			continue
+		}
+		err := a.EmailService.ReportResolved(report.ReporterEmail, report.Content(), resolution)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	if resolution == models.ResolutionDismissed || len(resolved) == 0 {
+		return
+	}
+	report := resolved[0]
+	err := a.EmailService.ContentModerated(report.OwnerEmail, report.Content(), report.Reason, resolution)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// UnsuspendUserHandler lifts the suspension of a user.
+//
+// This handler expects to sit behind userMiddleware.RequireAdmin
+func (a Admin) UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+	err = a.UserService.Unsuspend(userID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/reports", http.StatusFound)
+}
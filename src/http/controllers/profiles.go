@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAvatarSize is the largest avatar that can be uploaded in bytes.
+const maxAvatarSize = 2 << 20
+
+// profileUser looks up the user in the handle URL parameter for their public
+// pages and renders an error if that fails. Requests using a previous handle
+// are redirected to the current one.
+func (u Users) profileUser(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	handle := chi.URLParam(r, "handle")
+	user, err := u.UserService.ByHandle(handle)
+	if err == nil && !user.SuspendedAt.IsZero() {
+		// the profile itself may be why the user was suspended
+		err = models.ErrResourceNotFound
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrResourceNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return nil, err
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, err
+	}
+	if user.Handle != handle {
+		// feeds keep their part of the URL
+		path := "/u/" + user.Handle + strings.TrimPrefix(r.URL.Path, "/u/"+handle)
+		http.Redirect(w, r, withQuery(path, r), http.StatusMovedPermanently)
+		return nil, fmt.Errorf("user has moved to %v", path)
+	}
+	return user, nil
+}
+
+// ProfileHandler shows the public profile of a user with their listable
+// galleries.
+func (u Users) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := u.profileUser(w, r)
+	if err != nil {
+		return
+	}
+	galleries, err := u.GalleryService.PublishedByUserID(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	followers, err := u.FollowService.FollowerCount(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	following := false
+	viewer := context.User(r.Context())
+	if viewer != nil {
+		following, err = u.FollowService.IsFollowing(viewer.ID, user.ID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var data struct {
+		Handle    string
+		Name      string
+		Initial   string
+		Bio       string
+		AvatarKey string
+		Followers int
+		// CanFollow is false for anonymous visitors and on the profile of
+		// the viewer.
+		CanFollow bool
+		Following bool
+		Galleries []models.Gallery
+	}
+	data.Handle = user.Handle
+	data.Name = user.Name()
+	initial, _ := utf8.DecodeRuneInString(data.Name)
+	data.Initial = strings.ToUpper(string(initial))
+	data.Bio = user.Bio
+	data.AvatarKey = user.AvatarKey
+	data.Followers = followers
+	data.CanFollow = viewer != nil && viewer.ID != user.ID
+	data.Following = following
+	data.Galleries = galleries
+	u.Templates.Profile.Execute(w, r, data)
+}
+
+// AvatarHandler serves an avatar image. Avatar keys change with every upload,
+// so avatars can be cached for a long time.
+func (u Users) AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := u.UserService.Avatar(chi.URLParam(r, "key"))
+	if err != nil {
+		if errors.Is(err, models.ErrAvatarNotFound) {
+			http.Error(w, "Avatar not found", http.StatusNotFound)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, path)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (u Users) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	edited := *user
+	edited.DisplayName = r.FormValue("display_name")
+	edited.Bio = r.FormValue("bio")
+	err := u.UserService.UpdateProfile(user.ID, edited.DisplayName, edited.Bio)
+	if err != nil {
+		if errors.Is(err, models.ErrProfileTooLong) {
+			err = errors.Public(err, fmt.Sprintf(
+				"The display name can be at most %d and the bio at most %d characters long.",
+				models.MaxDisplayNameLength, models.MaxBioLength))
+		}
+		u.renderCurrentUser(w, r, edited, err)
+		return
+	}
+	http.Redirect(w, r, "/users/me", http.StatusFound)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (u Users) UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+1<<20)
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		u.renderCurrentUser(w, r, *user, errors.Public(err, "Please choose an image to upload."))
+		return
+	}
+	defer file.Close()
+	if header.Size > maxAvatarSize {
+		u.renderCurrentUser(w, r, *user, errors.Public(
+			fmt.Errorf("avatar too large: %d bytes", header.Size),
+			fmt.Sprintf("Avatars can be at most %v.", formatBytes(maxAvatarSize))))
+		return
+	}
+
+	err = u.UserService.SetAvatar(user.ID, header.Filename, file)
+	if err != nil {
+		var fileErr models.FileError
+		if errors.As(err, &fileErr) {
+			err = errors.Public(err, "Avatars have to be png, gif or jpg images.")
+		}
+		u.renderCurrentUser(w, r, *user, err)
+		return
+	}
+	http.Redirect(w, r, "/users/me", http.StatusFound)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (u Users) RemoveAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	err := u.UserService.RemoveAvatar(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/users/me", http.StatusFound)
+}
@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/cookie"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type proofingImageData struct {
+	imageData
+	Selected bool
+	Note     string
+}
+
+type proofingData struct {
+	GalleryID     int
+	Path          string
+	Title         string
+	Limit         int
+	MaxNoteLength int
+	// Submitted is only set right after the visitor submitted a selection.
+	Submitted   bool
+	ClientName  string
+	ClientEmail string
+	Note        string
+	Images      []proofingImageData
+}
+
+type selectionData struct {
+	ID              int
+	ClientName      string
+	ClientEmail     string
+	Note            string
+	SubmittedAt     string
+	Picks           []models.ProofingPick
+	LightroomFilter string
+}
+
+func proofingCookieName(gallery *models.Gallery) string {
+	return cookie.CookieProofing + strconv.Itoa(gallery.ID)
+}
+
+func proofingPath(gallery *models.Gallery) string {
+	return gallery.Path() + "/proofing"
+}
+
+// parseProofingLimit parses the selection limit from the edit form. An empty
+// limit means there is no limit.
+func parseProofingLimit(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, errors.Public(fmt.Errorf("invalid proofing limit %q", value),
+			"The selection limit has to be a positive number, or empty for no limit.")
+	}
+	return limit, nil
+}
+
+func (g Galleries) proofingMustBeEnabled(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	if !gallery.ProofingEnabled {
+		http.Error(w, "Proofing is not enabled for this gallery", http.StatusNotFound)
+		return fmt.Errorf("proofing is not enabled for this gallery")
+	}
+	return nil
+}
+
+// ProofingHandler shows the page where visitors of the gallery select
+// images. The draft of the visitor is found through a cookie, so visitors
+// don't need an account.
+func (g Galleries) ProofingHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryBySlug(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked, g.proofingMustBeEnabled)
+	if err != nil {
+		return
+	}
+	if r.URL.Query().Get("submitted") == "true" {
+		g.Templates.Proofing.Execute(w, r, proofingData{
+			GalleryID: gallery.ID,
+			Path:      gallery.Path(),
+			Title:     gallery.Title,
+			Submitted: true,
+		})
+		return
+	}
+
+	selection := &models.ProofingSelection{}
+	token, err := cookie.Read(r, proofingCookieName(gallery))
+	if err == nil {
+		draft, err := g.ProofingService.Draft(gallery.ID, token)
+		switch {
+		case err == nil:
+			selection = draft
+		case !errors.Is(err, models.ErrSelectionNotFound):
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+	g.renderProofing(w, r, gallery, selection)
+}
+
+// renderProofing shows the proofing page with the selection. Errors are
+// rendered as alerts on top of the page.
+func (g Galleries) renderProofing(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, selection *models.ProofingSelection, errs ...error) {
+	images, err := g.GalleryService.Images(gallery.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	picks := map[int]models.ProofingPick{}
+	for _, pick := range selection.Picks {
+		picks[pick.ImageID] = pick
+	}
+
+	data := proofingData{
+		GalleryID:     gallery.ID,
+		Path:          gallery.Path(),
+		Title:         gallery.Title,
+		Limit:         gallery.ProofingLimit,
+		MaxNoteLength: models.MaxProofingNoteLength,
+		ClientName:    selection.ClientName,
+		ClientEmail:   selection.ClientEmail,
+		Note:          selection.Note,
+	}
+	for _, image := range images {
+		pick, selected := picks[image.ID]
+		data.Images = append(data.Images, proofingImageData{
+			imageData: newImageData(image),
+			Selected:  selected,
+			Note:      pick.Note,
+		})
+	}
+	g.Templates.Proofing.Execute(w, r, data, errs...)
+}
+
+// SaveProofingHandler saves the selection of a visitor as a draft, or
+// submits it and lets the owner know by email.
+func (g Galleries) SaveProofingHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked, g.proofingMustBeEnabled)
+	if err != nil {
+		return
+	}
+	images, err := g.GalleryService.Images(gallery.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	details := models.ProofingSelection{
+		ClientName:  r.FormValue("name"),
+		ClientEmail: r.FormValue("email"),
+		Note:        r.FormValue("note"),
+	}
+	selected := map[string]bool{}
+	for _, key := range r.Form["selected"] {
+		selected[key] = true
+	}
+	for _, image := range images {
+		if !selected[image.Key] {
+			continue
+		}
+		details.Picks = append(details.Picks, models.ProofingPick{
+			ImageID:  image.ID,
+			ImageKey: image.Key,
+			Filename: image.Filename,
+			Note:     strings.TrimSpace(r.FormValue("note-" + image.Key)),
+		})
+	}
+
+	cookieName := proofingCookieName(gallery)
+	token, _ := cookie.Read(r, cookieName)
+	submit := r.FormValue("action") == "submit"
+	if submit {
+		// visitors don't need an account, so submissions are limited per
+		// client to keep them from flooding the owner with emails
+		limitKey := strconv.Itoa(gallery.ID) + "|" + clientIP(r)
+		if !g.ProofingLimiter.Hit(limitKey) {
+			minutes := math.Ceil(g.ProofingLimiter.RetryAfter(limitKey).Minutes())
+			g.renderProofing(w, r, gallery, &details, errors.Public(fmt.Errorf("too many selections"),
+				fmt.Sprintf("You have submitted too many selections. Please try again in %.0f minutes.", minutes)))
+			return
+		}
+	}
+	selection, err := g.ProofingService.Save(*gallery, token, details, submit)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrSelectionLimit):
+			err = errors.Public(err, fmt.Sprintf("You can select at most %d images.", gallery.ProofingLimit))
+		case errors.Is(err, models.ErrInvalidSelection):
+			err = errors.Public(err, fmt.Sprintf(
+				"Please enter your name, select at least one image and keep notes under %d characters.",
+				models.MaxProofingNoteLength))
+		default:
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		g.renderProofing(w, r, gallery, &details, err)
+		return
+	}
+
+	if !selection.Submitted() {
+		cookie.Set(w, cookieName, selection.Token)
+		http.Redirect(w, r, proofingPath(gallery), http.StatusFound)
+		return
+	}
+
+	cookie.Delete(w, cookieName)
+	reviewURL := fmt.Sprintf("%s/galleries/%d/selections", g.BaseURL, gallery.ID)
+	err = g.EmailService.ProofingSubmitted(selection.OwnerEmail, selection.ClientName,
+		gallery.Title, len(selection.Picks), reviewURL)
+	if err != nil {
+		// the selection is saved and listed for the owner anyway, so the
+		// visitor doesn't need to know
+		fmt.Println(err)
+	}
+	http.Redirect(w, r, proofingPath(gallery)+"?submitted=true", http.StatusFound)
+}
+
+// SelectionsHandler lists the submitted selections of the gallery for the
+// owner.
+func (g Galleries) SelectionsHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionEditSettings))
+	if err != nil {
+		return
+	}
+	selections, err := g.ProofingService.Submitted(gallery.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		GalleryID       int
+		Title           string
+		ProofingEnabled bool
+		ProofingURL     string
+		Selections      []selectionData
+	}
+	data.GalleryID = gallery.ID
+	data.Title = gallery.Title
+	data.ProofingEnabled = gallery.ProofingEnabled
+	data.ProofingURL = g.BaseURL + proofingPath(gallery)
+	for _, selection := range selections {
+		data.Selections = append(data.Selections, selectionData{
+			ID:              selection.ID,
+			ClientName:      selection.ClientName,
+			ClientEmail:     selection.ClientEmail,
+			Note:            selection.Note,
+			SubmittedAt:     selection.SubmittedAt.Format("Jan 2, 2006 15:04"),
+			Picks:           selection.Picks,
+			LightroomFilter: selection.LightroomFilter(),
+		})
+	}
+	g.Templates.Selections.Execute(w, r, data)
+}
+
+// ExportSelectionHandler downloads a submitted selection as CSV with the
+// filename and note of every selected image.
+func (g Galleries) ExportSelectionHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionEditSettings))
+	if err != nil {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "selectionID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+	selection, err := g.ProofingService.Submission(gallery.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrSelectionNotFound) {
+			http.Error(w, "Selection not found", http.StatusNotFound)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="selection-%d.csv"`, selection.ID))
+	writer := csv.NewWriter(w)
+	records := [][]string{{"filename", "note"}}
+	for _, pick := range selection.Picks {
+		records = append(records, []string{csvSafe(pick.Filename), csvSafe(pick.Note)})
+	}
+	err = writer.WriteAll(records)
+	if err != nil {
+		// the headers are sent already, so all we can do is log the error
+		fmt.Println(err)
+	}
+}
+
+// csvSafe keeps spreadsheet apps from evaluating values that start like a
+// formula. Notes are written by visitors, so they can't be trusted.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
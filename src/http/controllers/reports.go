@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+type reportFormData struct {
+	GalleryID int
+	// Path is the page of the reported content, where reporters can go back
+	// to.
+	Path string
+	// Content describes what is reported, for example the gallery "Summer".
+	Content   string
+	ImageKey  string
+	CommentID int
+	Reasons   []models.ReportReason
+	Reason    models.ReportReason
+	Details   string
+	MaxLength int
+	// Submitted is only set right after the user filed the report.
+	Submitted bool
+}
+
+// reportTarget finds the content a report is about: the gallery, or the
+// image or comment of the gallery named in the form. Reports about comments
+// on images name both. The page of the content is returned as well.
+func (g Galleries) reportTarget(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (models.Report, string, error) {
+	report := models.Report{
+		GalleryID:    gallery.ID,
+		GalleryTitle: gallery.Title,
+	}
+	path := gallery.Path()
+	if key := r.FormValue("image"); key != "" {
+		image, err := g.GalleryService.Image(gallery.ID, key)
+		if err != nil {
+			if errors.Is(err, models.ErrImageNotFound) {
+				http.Error(w, "Image not found", http.StatusNotFound)
+				return report, "", err
+			}
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return report, "", err
+		}
+		report.ImageID = image.ID
+		report.ImageKey = image.Key
+		path = imagePagePath(gallery, image.Key)
+	}
+	if value := r.FormValue("comment"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid comment", http.StatusBadRequest)
+			return report, "", err
+		}
+		comment, err := g.CommentService.Find(gallery.ID, id)
+		if err == nil && comment.ImageID != report.ImageID {
+			err = models.ErrCommentNotFound
+		}
+		if err != nil {
+			if errors.Is(err, models.ErrCommentNotFound) {
+				http.Error(w, "Comment not found", http.StatusNotFound)
+				return report, "", err
+			}
+			fmt.Println(err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return report, "", err
+		}
+		report.CommentID = comment.ID
+		path = fmt.Sprintf("%s#comment-%d", path, comment.ID)
+	}
+	return report, path, nil
+}
+
+// ReportFormHandler asks for the reason of a report about the gallery, or
+// about the image or comment named in the query.
+//
+// This handler expects to sit behind userMiddleware.RequireUser
+func (g Galleries) ReportFormHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked)
+	if err != nil {
+		return
+	}
+	if r.URL.Query().Get("submitted") == "true" {
+		g.Templates.Report.Execute(w, r, reportFormData{
+			GalleryID: gallery.ID,
+			Path:      gallery.Path(),
+			Submitted: true,
+		})
+		return
+	}
+	report, path, err := g.reportTarget(w, r, gallery)
+	if err != nil {
+		return
+	}
+	g.renderReportForm(w, r, report, path)
+}
+
+func (g Galleries) renderReportForm(w http.ResponseWriter, r *http.Request, report models.Report, path string, errs ...error) {
+	g.Templates.Report.Execute(w, r, reportFormData{
+		GalleryID: report.GalleryID,
+		Path:      path,
+		Content:   report.Content(),
+		ImageKey:  report.ImageKey,
+		CommentID: report.CommentID,
+		Reasons:   models.ReportReasons,
+		Reason:    report.Reason,
+		Details:   report.Details,
+		MaxLength: models.MaxReportDetailsLength,
+	}, errs...)
+}
+
+// ReportHandler files a report for the admins to review. The number of
+// reports is limited per user, so the queue can't be flooded.
+//
+// This handler expects to sit behind userMiddleware.RequireUser
+func (g Galleries) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCanViewGallery, g.galleryMustBeUnlocked)
+	if err != nil {
+		return
+	}
+	report, path, err := g.reportTarget(w, r, gallery)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	report.ReporterID = user.ID
+	report.Reason = models.ReportReason(r.FormValue("reason"))
+	report.Details = r.FormValue("details")
+
+	// the report is counted before it is filed, so parallel requests can't
+	// all pass the limit
+	limitKey := strconv.Itoa(user.ID)
+	if !g.ReportLimiter.Hit(limitKey) {
+		minutes := math.Ceil(g.ReportLimiter.RetryAfter(limitKey).Minutes())
+		g.renderReportForm(w, r, report, path, errors.Public(fmt.Errorf("too many reports"),
+			fmt.Sprintf("You have filed too many reports. Please try again in %.0f minutes.", minutes)))
+		return
+	}
+
+	err = g.ReportService.Create(report)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidReport) {
+			err = errors.Public(err, fmt.Sprintf(
+				"Please pick a reason and keep the details under %d characters.",
+				models.MaxReportDetailsLength))
+			g.renderReportForm(w, r, report, path, err)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/galleries/%d/report?submitted=true", gallery.ID), http.StatusFound)
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+// searchResultLimit is the number of galleries and of images shown for a
+// search.
+const searchResultLimit = 50
+
+type Search struct {
+	Templates struct {
+		Search Template
+	}
+	SearchService *models.SearchService
+}
+
+type gallerySearchData struct {
+	ID          int
+	Path        string
+	CoverKey    string
+	UserHandle  string
+	Title       template.HTML
+	Description template.HTML
+}
+
+type imageSearchData struct {
+	imageData
+	GalleryTitle string
+	GalleryPath  string
+	Highlight    template.HTML
+}
+
+// SearchHandler searches galleries and images for the q query parameter.
+// Signed in users search their own galleries in addition to the public ones.
+func (s Search) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Query     string
+		Galleries []gallerySearchData
+		Images    []imageSearchData
+	}
+	data.Query = strings.TrimSpace(r.FormValue("q"))
+	if data.Query == "" {
+		s.Templates.Search.Execute(w, r, data)
+		return
+	}
+
+	userID := 0
+	if user := context.User(r.Context()); user != nil {
+		userID = user.ID
+	}
+	galleries, err := s.SearchService.Galleries(data.Query, userID, searchResultLimit)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	images, err := s.SearchService.Images(data.Query, userID, searchResultLimit)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	for _, gallery := range galleries {
+		data.Galleries = append(data.Galleries, gallerySearchData{
+			ID:          gallery.ID,
+			Path:        gallery.Path(),
+			CoverKey:    gallery.CoverKey,
+			UserHandle:  gallery.UserHandle,
+			Title:       highlight(gallery.TitleHeadline),
+			Description: highlight(gallery.DescriptionHeadline),
+		})
+	}
+	for _, image := range images {
+		data.Images = append(data.Images, imageSearchData{
+			imageData:    newImageData(image.Image),
+			GalleryTitle: image.GalleryTitle,
+			GalleryPath:  image.GalleryPath,
+			Highlight:    highlight(image.CaptionHeadline),
+		})
+	}
+	s.Templates.Search.Execute(w, r, data)
+}
+
+// highlight escapes a search headline and turns its highlight markers into
+// mark elements.
+func highlight(headline string) template.HTML {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, models.HighlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, models.HighlightStop, "</mark>")
+	return template.HTML(escaped)
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/cookie"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const shareTokenParam = "share"
+
+type shareLinkData struct {
+	ID        int
+	Label     string
+	CreatedAt string
+	ExpiresAt string
+	Expired   bool
+	ViewCount int
+}
+
+func newShareLinkData(shareLink models.ShareLink) shareLinkData {
+	data := shareLinkData{
+		ID:        shareLink.ID,
+		Label:     shareLink.Label,
+		CreatedAt: shareLink.CreatedAt.Format("Jan 2, 2006"),
+		Expired:   shareLink.Expired(),
+		ViewCount: shareLink.ViewCount,
+	}
+	if !shareLink.ExpiresAt.IsZero() {
+		data.ExpiresAt = shareLink.ExpiresAt.Format("Jan 2, 2006")
+	}
+	return data
+}
+
+func (g Galleries) CreateShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionEditSettings))
+	if err != nil {
+		return
+	}
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	expiresOn, err := parseDateInput(r.FormValue("expires_on"))
+	if err != nil {
+		g.renderEditGallery(w, r, gallery, errors.Public(err, "The expiry date is not a valid date."))
+		return
+	}
+	var expiresAt time.Time
+	if !expiresOn.IsZero() {
+		// links stay valid for the whole expiry day
+		expiresAt = expiresOn.AddDate(0, 0, 1)
+		if expiresAt.Before(time.Now()) {
+			err = errors.Public(fmt.Errorf("share link expires in the past"),
+				"The expiry date must not be in the past.")
+			g.renderEditGallery(w, r, gallery, err)
+			return
+		}
+	}
+
+	shareLink, err := g.ShareLinkService.Create(gallery.ID, label, expiresAt)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := g.editGalleryData(r, gallery)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	data.NewShareURL = g.BaseURL + gallery.Path() + "?" +
+		url.Values{shareTokenParam: {shareLink.Token}}.Encode()
+	g.Templates.EditGallery.Execute(w, r, data)
+}
+
+func (g Galleries) DeleteShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionEditSettings))
+	if err != nil {
+		return
+	}
+	shareLinkID, err := strconv.Atoi(chi.URLParam(r, "linkID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	err = g.ShareLinkService.Delete(gallery.ID, shareLinkID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// validShareLink returns the share link the visitor holds for the gallery, or
+// nil if there is none. Tokens come from the URL of the shared link and are
+// remembered in a cookie, so that images of the gallery load as well.
+func (g Galleries) validShareLink(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) *models.ShareLink {
+	cookieName := cookie.CookieShareLink + strconv.Itoa(gallery.ID)
+	if token := r.URL.Query().Get(shareTokenParam); token != "" {
+		shareLink, err := g.ShareLinkService.Validate(gallery.ID, token)
+		if err == nil {
+			cookie.Set(w, cookieName, token)
+			return shareLink
+		}
+	}
+
+	token, err := cookie.Read(r, cookieName)
+	if err != nil {
+		return nil
+	}
+	shareLink, err := g.ShareLinkService.Validate(gallery.ID, token)
+	if err != nil {
+		return nil
+	}
+	return shareLink
+}
+
+// recordShareLinkView counts a view of the share link in the URL, if any.
+// Requests that only carry the cookie are not counted, so browsing the images
+// of a gallery doesn't inflate the counter.
+func (g Galleries) recordShareLinkView(r *http.Request, gallery *models.Gallery) {
+	token := r.URL.Query().Get(shareTokenParam)
+	if token == "" || !gallery.Unlisted {
+		return
+	}
+	shareLink, err := g.ShareLinkService.Validate(gallery.ID, token)
+	if err != nil {
+		return
+	}
+	err = g.ShareLinkService.RecordView(shareLink.ID)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
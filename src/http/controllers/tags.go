@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// tagSuggestionLimit is the number of tags returned for autocompletion.
+const tagSuggestionLimit = 10
+
+type taggedImageData struct {
+	imageData
+	GalleryTitle string
+	GalleryPath  string
+}
+
+// TagHandler lists the public galleries and images with a tag. Tags in the
+// URL that are not normalized are redirected to the normalized tag.
+func (g Galleries) TagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := models.NormalizeTag(chi.URLParam(r, "tag"))
+	if tag == "" {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+	if tag != chi.URLParam(r, "tag") {
+		http.Redirect(w, r, tagPath(tag), http.StatusMovedPermanently)
+		return
+	}
+
+	galleries, err := g.TagService.PublicGalleries(tag)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	images, err := g.TagService.PublicImages(tag)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		Tag       string
+		Galleries []models.Gallery
+		Images    []taggedImageData
+	}
+	data.Tag = tag
+	data.Galleries = galleries
+	for _, image := range images {
+		data.Images = append(data.Images, taggedImageData{
+			imageData:    newImageData(image.Image),
+			GalleryTitle: image.GalleryTitle,
+			GalleryPath:  image.GalleryPath,
+		})
+	}
+	g.Templates.Tag.Execute(w, r, data)
+}
+
+// SuggestTagsHandler returns tags starting with the q query parameter as a
+// JSON array, for autocompletion on the edit page.
+func (g Galleries) SuggestTagsHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	tags, err := g.TagService.Suggest(r.FormValue("q"), user.ID, tagSuggestionLimit)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func tagPath(tag string) string {
+	return "/tags/" + url.PathEscape(tag)
+}
+
+// joinTags formats tags for the comma separated tag inputs.
+func joinTags(tags []string) string {
+	return strings.Join(tags, ", ")
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+)
+
+type transferData struct {
+	ToEmail   string
+	ExpiresAt string
+}
+
+func newTransferData(transfer *models.Transfer) *transferData {
+	if transfer == nil {
+		return nil
+	}
+	return &transferData{
+		ToEmail:   transfer.ToEmail,
+		ExpiresAt: transfer.ExpiresAt.Format("Jan 2, 2006"),
+	}
+}
+
+// StartTransferHandler emails the recipient a link to accept the gallery.
+// The gallery only changes hands once the recipient accepts. Emails without
+// an account get the link as well, so the owner can't tell them apart.
+func (g Galleries) StartTransferHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionTransfer))
+	if err != nil {
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	transfer, err := g.TransferService.Start(*gallery, email)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidTransfer) {
+			err = errors.Public(err, "You already own this gallery.")
+		}
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+
+	vals := url.Values{
+		"token": {transfer.Token},
+	}
+	err = g.EmailService.GalleryTransfer(transfer.ToEmail, context.User(r.Context()).Handle,
+		gallery.Title, g.BaseURL+"/transfers/accept?"+vals.Encode())
+	if err != nil {
+		g.renderEditGallery(w, r, gallery, err)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) CancelTransferHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionTransfer))
+	if err != nil {
+		return
+	}
+	err = g.TransferService.Cancel(gallery.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+type acceptTransferData struct {
+	Token        string
+	GalleryTitle string
+}
+
+// AcceptTransferFormHandler asks the recipient to confirm taking over the
+// gallery. Only the user the transfer is addressed to can see it.
+func (g Galleries) AcceptTransferFormHandler(w http.ResponseWriter, r *http.Request) {
+	data := acceptTransferData{
+		Token: r.FormValue("token"),
+	}
+	transfer, err := g.TransferService.Find(data.Token, context.User(r.Context()).ID)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidTransfer) {
+			err = errors.Public(err, "This transfer is invalid or has expired, or it is addressed to another account.")
+		}
+		g.Templates.AcceptTransfer.Execute(w, r, data, err)
+		return
+	}
+
+	gallery, err := g.GalleryService.FindByID(transfer.GalleryID)
+	if err != nil {
+		g.Templates.AcceptTransfer.Execute(w, r, data, err)
+		return
+	}
+	data.GalleryTitle = gallery.Title
+
+	g.Templates.AcceptTransfer.Execute(w, r, data)
+}
+
+func (g Galleries) AcceptTransferHandler(w http.ResponseWriter, r *http.Request) {
+	data := acceptTransferData{
+		Token: r.FormValue("token"),
+	}
+	transfer, err := g.TransferService.Accept(data.Token, context.User(r.Context()).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidTransfer):
+			err = errors.Public(err, "This transfer is invalid or has expired, or it is addressed to another account.")
+		case errors.Is(err, models.ErrQuotaExceeded):
+			err = errors.Public(err, "The images of this gallery don't fit into your storage quota. Free some space or ask an admin for more, then open the link of this transfer again.")
+		}
+		g.Templates.AcceptTransfer.Execute(w, r, data, err)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", transfer.GalleryID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Shamanskiy/lenslocked/src/errors"
+	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type trashedGalleryData struct {
+	ID        int
+	Title     string
+	DeletedOn string
+	PurgesOn  string
+}
+
+type trashedImageData struct {
+	imageData
+	DeletedOn string
+	PurgesOn  string
+}
+
+// TrashHandler lists the galleries of the current user in the trash.
+func (g Galleries) TrashHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	galleries, err := g.GalleryService.TrashedGalleries(user.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		Galleries []trashedGalleryData
+	}
+	for _, gallery := range galleries {
+		data.Galleries = append(data.Galleries, trashedGalleryData{
+			ID:        gallery.ID,
+			Title:     gallery.Title,
+			DeletedOn: gallery.DeletedAt.Format("Jan 2, 2006"),
+			PurgesOn:  g.GalleryService.PurgesAt(gallery.DeletedAt).Format("Jan 2, 2006"),
+		})
+	}
+	g.Templates.Trash.Execute(w, r, data)
+}
+
+func (g Galleries) RestoreGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.trashedGalleryByID(w, r, g.userCan(models.ActionDelete))
+	if err != nil {
+		return
+	}
+	err = g.GalleryService.RestoreGallery(gallery.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// PurgeGalleryHandler deletes a gallery in the trash for good.
+func (g Galleries) PurgeGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.trashedGalleryByID(w, r, g.userCan(models.ActionDelete))
+	if err != nil {
+		return
+	}
+	err = g.GalleryService.Purge(*gallery)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/trash", http.StatusFound)
+}
+
+func (g Galleries) RestoreImageHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageImages))
+	if err != nil {
+		return
+	}
+	err = g.GalleryService.RestoreImage(gallery.ID, imageKey(r))
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateImage) {
+			g.renderEditGallery(w, r, gallery, errors.Public(err,
+				"The same image is already in this gallery. Delete it first to restore this one."))
+			return
+		}
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// PurgeImageHandler deletes an image in the trash for good.
+func (g Galleries) PurgeImageHandler(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, g.userCan(models.ActionManageImages))
+	if err != nil {
+		return
+	}
+	_, err = g.GalleryService.TrashedImage(gallery.ID, imageKey(r))
+	if err == nil {
+		err = g.GalleryService.PurgeImage(gallery.ID, imageKey(r))
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrImageNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		fmt.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// trashedGalleryByID works like galleryByID for galleries in the trash.
+func (g Galleries) trashedGalleryByID(w http.ResponseWriter, r *http.Request, opts ...galleryOpt) (*models.Gallery, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := g.GalleryService.FindTrashed(id)
+	return g.checkGallery(w, r, gallery, err, opts...)
+}
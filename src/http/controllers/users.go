@@ -19,12 +19,17 @@ type Users struct {
 		ForgotPassword Template
 		CheckYourEmail Template
 		ResetPassword  Template
+		Profile        Template
 	}
 	UserService          *models.UserService
 	SessionService       *models.SessionService
 	PasswordResetService *models.PasswordResetService
 	EmailService         *models.EmailService
-	ServerAddress        string
+	QuotaService         *models.QuotaService
+	GalleryService       *models.GalleryService
+	FollowService        *models.FollowService
+	// BaseURL is the URL the site is reached at, for emails and feeds.
+	BaseURL string
 }
 
 func (u Users) SignUpFormHandler(w http.ResponseWriter, r *http.Request) {
@@ -79,6 +84,8 @@ func (u Users) SignInHandler(w http.ResponseWriter, r *http.Request) {
 			err = errors.Public(err, "No account found associated with this email.")
 		} else if errors.Is(err, models.ErrPasswordWrong) {
 			err = errors.Public(err, "Provided password is wrong.")
+		} else if errors.Is(err, models.ErrUserSuspended) {
+			err = errors.Public(err, "Your account has been suspended.")
 		}
 		u.Templates.SignIn.Execute(w, r, emailData(email), err)
 		return
@@ -98,7 +105,56 @@ func (u Users) SignInHandler(w http.ResponseWriter, r *http.Request) {
 // so it doesn't check if the user exists
 func (u Users) CurrentUserHandler(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
-	u.Templates.CurrentUser.Execute(w, r, emailData(user.Email))
+	u.renderCurrentUser(w, r, *user)
+}
+
+// renderCurrentUser shows the page of the signed in user. The user is passed
+// separately from the one in the context, so rejected changes can be shown
+// back for editing.
+func (u Users) renderCurrentUser(w http.ResponseWriter, r *http.Request, edited models.User, errs ...error) {
+	user := context.User(r.Context())
+	usage, err := u.QuotaService.Usage(user.ID)
+	if err != nil {
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		Email       string
+		Handle      string
+		DisplayName string
+		Bio         string
+		AvatarKey   string
+		Digest      bool
+		Usage       usageData
+	}
+	data.Email = user.Email
+	data.Handle = edited.Handle
+	data.DisplayName = edited.DisplayName
+	data.Bio = edited.Bio
+	data.AvatarKey = user.AvatarKey
+	data.Digest = user.DigestEnabled
+	data.Usage = newUsageData(*usage)
+	u.Templates.CurrentUser.Execute(w, r, data, errs...)
+}
+
+// This handler expects to sit behind userMiddleware.RequireUser
+func (u Users) UpdateHandleHandler(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	handle := r.FormValue("handle")
+	err := u.UserService.UpdateHandle(user.ID, handle)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidHandle) {
+			err = errors.Public(err, "Handles may only contain lowercase letters, digits and dashes, and can't start or end with a dash.")
+		} else if errors.Is(err, models.ErrHandleTaken) {
+			err = errors.Public(err, "That handle is already taken.")
+		}
+		edited := *user
+		edited.Handle = handle
+		u.renderCurrentUser(w, r, edited, err)
+		return
+	}
+	http.Redirect(w, r, "/users/me", http.StatusFound)
 }
 
 func (u Users) SignOutHandler(w http.ResponseWriter, r *http.Request) {
@@ -139,9 +195,8 @@ func (u Users) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	vals := url.Values{
 		"token": {pwReset.Token},
 	}
-	// TODO: Make the URL here configurable
 	err = u.EmailService.ForgotPassword(data.Email,
-		"http://"+u.ServerAddress+"/reset-password?"+vals.Encode())
+		u.BaseURL+"/reset-password?"+vals.Encode())
 	if err != nil {
 		u.Templates.ForgotPassword.Execute(w, r, data, err)
 		return
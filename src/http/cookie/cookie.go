@@ -1,12 +1,27 @@
 package cookie
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	CookieSession = "session"
+	// CookieShareLink prefixes the cookies holding share link tokens. The
+	// gallery ID is appended, so a visitor can hold links to several galleries.
+	CookieShareLink = "share-"
+	// CookieUnlock prefixes the signed cookies remembering that a visitor has
+	// entered the password of a gallery. The gallery ID is appended.
+	CookieUnlock = "unlock-"
+	// CookieProofing prefixes the cookies holding the token of the draft
+	// proofing selection of a visitor. The gallery ID is appended.
+	CookieProofing = "proofing-"
 )
 
 func newCookie(name, value string) *http.Cookie {
@@ -37,3 +52,48 @@ func Delete(w http.ResponseWriter, name string) {
 	cookie.MaxAge = -1
 	http.SetCookie(w, cookie)
 }
+
+// SetSigned sets a cookie whose value is signed with the key and expires
+// after maxAge. The signature covers the cookie name and expiry as well, so
+// the value can't be moved to another cookie or kept alive for longer.
+func SetSigned(w http.ResponseWriter, name, value string, key []byte, maxAge time.Duration) {
+	expires := strconv.FormatInt(time.Now().Add(maxAge).Unix(), 10)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+	signature := sign(key, name, encoded, expires)
+	cookie := newCookie(name, encoded+"."+expires+"."+signature)
+	cookie.MaxAge = int(maxAge.Seconds())
+	cookie.SameSite = http.SameSiteLaxMode
+	http.SetCookie(w, cookie)
+}
+
+// ReadSigned returns the value of a cookie set with SetSigned. It returns an
+// error if the cookie is missing, has been tampered with or has expired.
+func ReadSigned(r *http.Request, name string, key []byte) (string, error) {
+	signed, err := Read(r, name)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%s: malformed signed cookie", name)
+	}
+	encoded, expires, signature := parts[0], parts[1], parts[2]
+	if !hmac.Equal([]byte(signature), []byte(sign(key, name, encoded, expires))) {
+		return "", fmt.Errorf("%s: invalid signature", name)
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresUnix {
+		return "", fmt.Errorf("%s: signed cookie expired", name)
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return string(value), nil
+}
+
+func sign(key []byte, name, encodedValue, expires string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "." + encodedValue + "." + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
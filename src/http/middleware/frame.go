@@ -0,0 +1,25 @@
+package middleware
+
+import "net/http"
+
+// DenyFraming keeps other sites from showing our pages in frames, so they
+// can't trick users into clicking buttons they don't see.
+func DenyFraming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// X-Frame-Options is for browsers that don't know frame-ancestors
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowFraming lets any site show the page in a frame. It overrides
+// DenyFraming for the pages that are meant to be embedded, which must not
+// have any buttons that change something.
+func AllowFraming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Del("X-Frame-Options")
+		w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+		next.ServeHTTP(w, r)
+	})
+}
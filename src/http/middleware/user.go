@@ -61,3 +61,15 @@ func (umw UserMiddleware) RequireUser(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireAdmin expects to sit behind RequireUser and only lets admins through.
+func (umw UserMiddleware) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil || !user.IsAdmin {
+			http.Error(w, "You are not authorized to view this page", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// runPeriodically runs the job right away and then once per interval for as
+// long as the server runs. Errors are logged and don't stop the job.
+func runPeriodically(name string, interval time.Duration, job func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := job()
+		if err != nil {
+			fmt.Printf("%s: %v\n", name, err)
+		}
+		<-ticker.C
+	}
+}
@@ -3,12 +3,14 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/Shamanskiy/lenslocked/src/assets"
 	"github.com/Shamanskiy/lenslocked/src/http/controllers"
 	"github.com/Shamanskiy/lenslocked/src/http/middleware"
 	"github.com/Shamanskiy/lenslocked/src/migrations"
 	"github.com/Shamanskiy/lenslocked/src/models"
+	"github.com/Shamanskiy/lenslocked/src/ratelimit"
 	"github.com/Shamanskiy/lenslocked/src/templates"
 	"github.com/Shamanskiy/lenslocked/src/views"
 	"github.com/go-chi/chi/v5"
@@ -21,8 +23,25 @@ type Config struct {
 		Key    string
 		Secure bool
 	}
+	Cookie struct {
+		// SigningKey signs cookies that must not be forged, like the ones
+		// remembering unlocked galleries.
+		SigningKey string
+	}
 	Server struct {
 		Address string
+		// BaseURL is the URL the site is reached at, without a trailing
+		// slash. It is used for links that leave the site, like in emails,
+		// feeds and embeds.
+		BaseURL string
+	}
+	Storage struct {
+		// DefaultQuota is the number of bytes each user can store unless an
+		// admin overrides it.
+		DefaultQuota int64
+		// TrashRetention is how long deleted galleries and images can be
+		// restored before they are purged.
+		TrashRetention time.Duration
 	}
 }
 
@@ -52,19 +71,45 @@ func Run(cfg Config) {
 		DB: db,
 	}
 
+	quotaService := &models.QuotaService{
+		DB:           db,
+		DefaultQuota: cfg.Storage.DefaultQuota,
+	}
+
 	galleryService := &models.GalleryService{
-		DB: db,
+		DB:             db,
+		TrashRetention: cfg.Storage.TrashRetention,
+		QuotaService:   quotaService,
+	}
+
+	err = galleryService.ImportLegacyImages()
+	if err != nil {
+		panic(err)
 	}
 
+	go runPeriodically("purge trash", time.Hour, galleryService.PurgeTrash)
+
 	emailService := models.NewEmailService(cfg.SMTP)
 
+	followService := &models.FollowService{
+		DB: db,
+	}
+
+	digestService := &models.DigestService{
+		DB:            db,
+		FollowService: followService,
+		EmailService:  emailService,
+		BaseURL:       cfg.Server.BaseURL,
+	}
+	go runPeriodically("send digests", time.Hour, digestService.SendDue)
+
 	userMiddleware := middleware.UserMiddleware{
 		SessionService: sessionService,
 	}
 
 	csrfMiddleware := middleware.CSRF(cfg.CSRF.Key, cfg.CSRF.Secure)
 
-	router.Use(middleware.Logger, csrfMiddleware, userMiddleware.SetUser)
+	router.Use(middleware.Logger, middleware.DenyFraming, csrfMiddleware, userMiddleware.SetUser)
 
 	contactTemplate := views.Must(views.ParseFS(templates.FS, "contact.gohtml", "tailwind.gohtml"))
 	faqTemplate := views.Must(views.ParseFS(templates.FS, "faq.gohtml", "tailwind.gohtml"))
@@ -76,7 +121,10 @@ func Run(cfg Config) {
 		SessionService:       sessionService,
 		PasswordResetService: pwResetService,
 		EmailService:         emailService,
-		ServerAddress:        cfg.Server.Address,
+		QuotaService:         quotaService,
+		GalleryService:       galleryService,
+		FollowService:        followService,
+		BaseURL:              cfg.Server.BaseURL,
 	}
 	usersController.Templates.CurrentUser = views.Must(views.ParseFS(templates.FS,
 		"users/currentUser.gohtml", "tailwind.gohtml"))
@@ -91,22 +139,137 @@ func Run(cfg Config) {
 	usersController.Templates.ResetPassword = views.Must(views.ParseFS(templates.FS,
 		"users/resetPassword.gohtml", "tailwind.gohtml",
 	))
+	usersController.Templates.Profile = views.Must(views.ParseFS(templates.FS,
+		"users/profile.gohtml", "tailwind.gohtml"))
+
+	shareLinkService := &models.ShareLinkService{
+		DB: db,
+	}
+
+	memberService := &models.MemberService{
+		DB: db,
+	}
+
+	transferService := &models.TransferService{
+		DB:           db,
+		QuotaService: quotaService,
+	}
+
+	tagService := &models.TagService{
+		DB: db,
+	}
+
+	commentService := &models.CommentService{
+		DB: db,
+	}
+
+	favoriteService := &models.FavoriteService{
+		DB: db,
+	}
+
+	proofingService := &models.ProofingService{
+		DB: db,
+	}
+
+	reportService := &models.ReportService{
+		DB: db,
+	}
+
+	analyticsService := &models.AnalyticsService{
+		DB: db,
+	}
+	go runPeriodically("purge view visitors", time.Hour, analyticsService.PurgeVisitors)
 
 	galleriesController := controllers.Galleries{
-		GalleryService: galleryService,
+		GalleryService:   galleryService,
+		ShareLinkService: shareLinkService,
+		MemberService:    memberService,
+		TransferService:  transferService,
+		TagService:       tagService,
+		CommentService:   commentService,
+		FavoriteService:  favoriteService,
+		ProofingService:  proofingService,
+		ReportService:    reportService,
+		AnalyticsService: analyticsService,
+		EmailService:     emailService,
+		BaseURL:          cfg.Server.BaseURL,
+		SigningKey:       []byte(cfg.Cookie.SigningKey),
+		UnlockLimiter:    ratelimit.New(5, 15*time.Minute),
+		CommentLimiter:   ratelimit.New(10, 10*time.Minute),
+		ReportLimiter:    ratelimit.New(10, time.Hour),
+		ProofingLimiter:  ratelimit.New(5, time.Hour),
 	}
 	galleriesController.Templates.NewGallery = views.Must(views.ParseFS(templates.FS,
-		"galleries/newGallery.gohtml", "tailwind.gohtml"))
+		"galleries/newGallery.gohtml", "galleries/galleryDetails.gohtml", "tailwind.gohtml"))
 	galleriesController.Templates.EditGallery = views.Must(views.ParseFS(templates.FS,
-		"galleries/editGallery.gohtml", "tailwind.gohtml"))
+		"galleries/editGallery.gohtml", "galleries/galleryDetails.gohtml", "tailwind.gohtml"))
 	galleriesController.Templates.IndexGalleries = views.Must(views.ParseFS(templates.FS,
 		"galleries/indexGalleries.gohtml", "tailwind.gohtml"))
 	galleriesController.Templates.ViewGallery = views.Must(views.ParseFS(templates.FS,
-		"galleries/viewGallery.gohtml", "tailwind.gohtml"))
+		"galleries/viewGallery.gohtml", "galleries/comments.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.ViewImage = views.Must(views.ParseFS(templates.FS,
+		"galleries/viewImage.gohtml", "galleries/comments.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.UnlockGallery = views.Must(views.ParseFS(templates.FS,
+		"galleries/unlockGallery.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.AcceptInvitation = views.Must(views.ParseFS(templates.FS,
+		"galleries/acceptInvitation.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.AcceptTransfer = views.Must(views.ParseFS(templates.FS,
+		"galleries/acceptTransfer.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Trash = views.Must(views.ParseFS(templates.FS,
+		"galleries/trash.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Tag = views.Must(views.ParseFS(templates.FS,
+		"galleries/tag.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Explore = views.Must(views.ParseFS(templates.FS,
+		"explore.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Favorites = views.Must(views.ParseFS(templates.FS,
+		"galleries/favorites.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Proofing = views.Must(views.ParseFS(templates.FS,
+		"galleries/proofing.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Selections = views.Must(views.ParseFS(templates.FS,
+		"galleries/selections.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Report = views.Must(views.ParseFS(templates.FS,
+		"galleries/report.gohtml", "tailwind.gohtml"))
+	galleriesController.Templates.Embed = views.Must(views.ParseFS(templates.FS,
+		"galleries/embed.gohtml"))
+
+	searchService := &models.SearchService{
+		DB: db,
+	}
+
+	searchController := controllers.Search{
+		SearchService: searchService,
+	}
+	searchController.Templates.Search = views.Must(views.ParseFS(templates.FS,
+		"search.gohtml", "tailwind.gohtml"))
+
+	adminController := controllers.Admin{
+		QuotaService:  quotaService,
+		UserService:   userService,
+		ReportService: reportService,
+		EmailService:  emailService,
+	}
+	adminController.Templates.Users = views.Must(views.ParseFS(templates.FS,
+		"admin/users.gohtml", "tailwind.gohtml"))
+	adminController.Templates.Reports = views.Must(views.ParseFS(templates.FS,
+		"admin/reports.gohtml", "tailwind.gohtml"))
 
 	router.Route("/users/me", func(r chi.Router) {
 		r.Use(userMiddleware.RequireUser)
 		r.Get("/", usersController.CurrentUserHandler)
+		r.Post("/handle", usersController.UpdateHandleHandler)
+		r.Post("/profile", usersController.UpdateProfileHandler)
+		r.Post("/avatar", usersController.UploadAvatarHandler)
+		r.Post("/avatar/delete", usersController.RemoveAvatarHandler)
+		r.Post("/digest", usersController.UpdateDigestHandler)
+	})
+
+	router.Route("/admin", func(r chi.Router) {
+		r.Use(userMiddleware.RequireUser, userMiddleware.RequireAdmin)
+		r.Get("/users", adminController.UsersHandler)
+		r.Post("/users/{id}/quota", adminController.SetQuotaHandler)
+		r.Post("/users/{id}/unsuspend", adminController.UnsuspendUserHandler)
+		r.Get("/reports", adminController.ReportsHandler)
+		r.Post("/reports/{id}/resolve", adminController.ResolveReportHandler)
 	})
 
 	router.Get("/signup", usersController.SignUpFormHandler)
@@ -121,8 +284,11 @@ func Run(cfg Config) {
 
 	// this redirects logged-out users to the sign-in page
 	router.Route("/galleries", func(r chi.Router) {
-		r.Get("/{id}", galleriesController.ViewGalleryHandler)
-		r.Get("/{id}/images/{filename}", galleriesController.ImageHandler)
+		r.Get("/{id}", galleriesController.RedirectGalleryHandler)
+		r.Get("/{id}/images/{key}", galleriesController.ImageHandler)
+		r.Get("/{id}/download", galleriesController.DownloadGalleryHandler)
+		r.Post("/{id}/unlock", galleriesController.UnlockGalleryHandler)
+		r.Post("/{id}/proofing", galleriesController.SaveProofingHandler)
 		r.Group(func(r chi.Router) {
 			r.Use(userMiddleware.RequireUser)
 			r.Get("/new-gallery", galleriesController.NewGalleryFormHandler)
@@ -131,12 +297,82 @@ func Run(cfg Config) {
 			r.Get("/{id}/edit", galleriesController.EditGalleryFormHandler)
 			r.Post("/{id}/edit", galleriesController.EditGalleryHandler)
 			r.Post("/{id}/delete", galleriesController.DeleteGalleryHandler)
-			r.Post("/{id}/images/{filename}/delete", galleriesController.DeleteImageHandler)
+			r.Post("/{id}/clone", galleriesController.CloneGalleryHandler)
+			r.Post("/{id}/images/{key}/delete", galleriesController.DeleteImageHandler)
+			r.Post("/{id}/images/{key}/restore", galleriesController.RestoreImageHandler)
+			r.Post("/{id}/images/{key}/purge", galleriesController.PurgeImageHandler)
 			r.Post("/{id}/images", galleriesController.UploadImageHandler)
+			r.Post("/{id}/images/details", galleriesController.UpdateImagesHandler)
+			r.Post("/{id}/share-links", galleriesController.CreateShareLinkHandler)
+			r.Post("/{id}/share-links/{linkID}/delete", galleriesController.DeleteShareLinkHandler)
+			r.Post("/{id}/password", galleriesController.SetPasswordHandler)
+			r.Post("/{id}/invitations", galleriesController.InviteMemberHandler)
+			r.Post("/{id}/invitations/{invitationID}/delete", galleriesController.DeleteInvitationHandler)
+			r.Post("/{id}/members/{userID}", galleriesController.UpdateMemberHandler)
+			r.Post("/{id}/members/{userID}/delete", galleriesController.RemoveMemberHandler)
+			r.Post("/{id}/transfer", galleriesController.StartTransferHandler)
+			r.Post("/{id}/transfer/cancel", galleriesController.CancelTransferHandler)
+			r.Post("/{id}/comments", galleriesController.CreateCommentHandler)
+			r.Post("/{id}/comments/{commentID}/hide", galleriesController.HideCommentHandler)
+			r.Post("/{id}/comments/{commentID}/show", galleriesController.ShowCommentHandler)
+			r.Post("/{id}/comments/{commentID}/delete", galleriesController.DeleteCommentHandler)
+			r.Post("/{id}/favorite", galleriesController.FavoriteGalleryHandler)
+			r.Post("/{id}/favorite/delete", galleriesController.UnfavoriteGalleryHandler)
+			r.Post("/{id}/images/{key}/favorite", galleriesController.FavoriteImageHandler)
+			r.Post("/{id}/images/{key}/favorite/delete", galleriesController.UnfavoriteImageHandler)
+			r.Get("/{id}/selections", galleriesController.SelectionsHandler)
+			r.Get("/{id}/selections/{selectionID}/export.csv", galleriesController.ExportSelectionHandler)
+			r.Get("/{id}/report", galleriesController.ReportFormHandler)
+			r.Post("/{id}/report", galleriesController.ReportHandler)
 		})
 	})
 
-	router.Get("/", controllers.Static(homeTemplate))
+	router.Route("/invitations", func(r chi.Router) {
+		r.Use(userMiddleware.RequireUser)
+		r.Get("/accept", galleriesController.AcceptInvitationFormHandler)
+		r.Post("/accept", galleriesController.AcceptInvitationHandler)
+	})
+
+	router.Route("/trash", func(r chi.Router) {
+		r.Use(userMiddleware.RequireUser)
+		r.Get("/", galleriesController.TrashHandler)
+		r.Post("/galleries/{id}/restore", galleriesController.RestoreGalleryHandler)
+		r.Post("/galleries/{id}/delete", galleriesController.PurgeGalleryHandler)
+	})
+
+	router.Route("/follows", func(r chi.Router) {
+		r.Use(userMiddleware.RequireUser)
+		r.Post("/{handle}", usersController.FollowHandler)
+		r.Post("/{handle}/delete", usersController.UnfollowHandler)
+	})
+
+	router.Route("/transfers", func(r chi.Router) {
+		r.Use(userMiddleware.RequireUser)
+		r.Get("/accept", galleriesController.AcceptTransferFormHandler)
+		r.Post("/accept", galleriesController.AcceptTransferHandler)
+	})
+
+	router.Route("/tags", func(r chi.Router) {
+		r.With(userMiddleware.RequireUser).Get("/suggest", galleriesController.SuggestTagsHandler)
+		r.Get("/{tag}", galleriesController.TagHandler)
+	})
+
+	router.Get("/u/{handle}", usersController.ProfileHandler)
+	router.Get("/u/{handle}/feed.atom", usersController.UserFeedHandler)
+	router.Get("/u/{handle}/feed.rss", usersController.UserFeedHandler)
+	router.Get("/u/{handle}/{slug}", galleriesController.ViewGalleryHandler)
+	router.Get("/u/{handle}/{slug}/images/{key}", galleriesController.ViewImageHandler)
+	router.Get("/u/{handle}/{slug}/proofing", galleriesController.ProofingHandler)
+	router.Get("/avatars/{key}", usersController.AvatarHandler)
+	router.Get("/search", searchController.SearchHandler)
+	router.Get("/explore", galleriesController.ExploreHandler)
+	router.Get("/feed.atom", galleriesController.SiteFeedHandler)
+	router.Get("/feed.rss", galleriesController.SiteFeedHandler)
+	router.Get("/oembed", galleriesController.OEmbedHandler)
+	router.With(middleware.AllowFraming).Get("/embed/galleries/{id}", galleriesController.EmbedHandler)
+	router.With(userMiddleware.RequireUser).Get("/favorites", galleriesController.FavoritesHandler)
+
+	router.Get("/", controllers.Home(homeTemplate, followService))
 	router.Get("/faq", controllers.FAQ(faqTemplate))
 	router.Get("/contact", controllers.Static(contactTemplate))
 	router.NotFound(controllers.NotFound(notFoundTemplate))
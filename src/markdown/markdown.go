@@ -0,0 +1,145 @@
+// Package markdown renders a small and safe subset of Markdown to HTML.
+//
+// The whole input is HTML-escaped before any formatting is applied, so text
+// written by users can never inject markup of its own. Supported syntax:
+// paragraphs, headings (#, ##, ###), bullet lists (- or *), **bold**,
+// *italic* or _italic_, `code` and [links](https://example.com). Links only
+// accept http, https and mailto URLs.
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	headingRe  = regexp.MustCompile(`^(#{1,3})\s+(.*)$`)
+	listItemRe = regexp.MustCompile(`^[-*]\s+(.*)$`)
+	codeRe     = regexp.MustCompile("`([^`]+)`")
+	linkRe     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	boldRe     = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*`)
+	starEmRe   = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*`)
+	// underscores inside words, like in snake_case, don't start emphasis
+	underscoreEmRe = regexp.MustCompile(`(^|[^\p{L}\p{N}_])_(\S(?:.*?\S)?)_($|[^\p{L}\p{N}_])`)
+	placeholderRe  = regexp.MustCompile("\x00([0-9]+)\x00")
+)
+
+var allowedSchemes = []string{"http://", "https://", "mailto:"}
+
+// Render converts the Markdown source into HTML that is safe to embed into
+// a page as is.
+func Render(source string) template.HTML {
+	// NUL bytes are used for placeholders during inline formatting
+	source = strings.ReplaceAll(source, "\x00", "")
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+
+	var out strings.Builder
+	var paragraph []string
+	var list []string
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + strings.Join(paragraph, "\n") + "</p>\n")
+			paragraph = nil
+		}
+	}
+	flushList := func() {
+		if len(list) > 0 {
+			out.WriteString("<ul>\n")
+			for _, item := range list {
+				out.WriteString("<li>" + item + "</li>\n")
+			}
+			out.WriteString("</ul>\n")
+			list = nil
+		}
+	}
+
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flushParagraph()
+			flushList()
+		case headingRe.MatchString(line):
+			flushParagraph()
+			flushList()
+			match := headingRe.FindStringSubmatch(line)
+			// h1 is reserved for page titles, so headings start at h2
+			level := len(match[1]) + 1
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", level, inline(match[2]), level)
+		case listItemRe.MatchString(line):
+			flushParagraph()
+			match := listItemRe.FindStringSubmatch(line)
+			list = append(list, inline(match[1]))
+		default:
+			flushList()
+			paragraph = append(paragraph, inline(line))
+		}
+	}
+	flushParagraph()
+	flushList()
+
+	return template.HTML(out.String())
+}
+
+// inline escapes the text and applies inline formatting. Code spans and
+// links are replaced with placeholders first, so that their contents aren't
+// affected by the emphasis rules.
+func inline(text string) string {
+	text = html.EscapeString(text)
+
+	// sources keeps the text each placeholder stands for, so that code spans
+	// inside URLs can be put back as they were written
+	var replacements, sources []string
+	placeholder := func(html, source string) string {
+		replacements = append(replacements, html)
+		sources = append(sources, source)
+		return fmt.Sprintf("\x00%d\x00", len(replacements)-1)
+	}
+	expand := func(text string, with []string) string {
+		return placeholderRe.ReplaceAllStringFunc(text, func(match string) string {
+			var i int
+			fmt.Sscanf(placeholderRe.FindStringSubmatch(match)[1], "%d", &i)
+			return with[i]
+		})
+	}
+
+	text = codeRe.ReplaceAllStringFunc(text, func(match string) string {
+		code := codeRe.FindStringSubmatch(match)[1]
+		return placeholder("<code>"+code+"</code>", match)
+	})
+	text = linkRe.ReplaceAllStringFunc(text, func(match string) string {
+		parts := linkRe.FindStringSubmatch(match)
+		// labels may contain code spans, which have to be expanded here as
+		// the link itself becomes a placeholder
+		label := expand(emphasis(parts[1]), replacements)
+		url := expand(parts[2], sources)
+		if !allowedURL(html.UnescapeString(url)) {
+			return placeholder(label, match)
+		}
+		return placeholder(`<a href="`+url+`" rel="nofollow noopener">`+label+`</a>`, match)
+	})
+	text = emphasis(text)
+
+	return expand(text, replacements)
+}
+
+func emphasis(text string) string {
+	text = boldRe.ReplaceAllString(text, "<strong>$1</strong>")
+	text = starEmRe.ReplaceAllString(text, "<em>$1</em>")
+	text = underscoreEmRe.ReplaceAllString(text, "$1<em>$2</em>$3")
+	return text
+}
+
+func allowedURL(url string) bool {
+	url = strings.ToLower(url)
+	for _, scheme := range allowedSchemes {
+		if strings.HasPrefix(url, scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "paragraph",
+			source: "Hello **bold** and *italic* and _also italic_",
+			want:   "<p>Hello <strong>bold</strong> and <em>italic</em> and <em>also italic</em></p>\n",
+		},
+		{
+			name:   "heading",
+			source: "# Title",
+			want:   "<h2>Title</h2>\n",
+		},
+		{
+			name:   "list",
+			source: "- one\n* two",
+			want:   "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n",
+		},
+		{
+			name:   "html is escaped",
+			source: `<script>alert("x")</script> & <b>bold</b>`,
+			want:   "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; &lt;b&gt;bold&lt;/b&gt;</p>\n",
+		},
+		{
+			name:   "underscores inside words",
+			source: "snake_case_name",
+			want:   "<p>snake_case_name</p>\n",
+		},
+		{
+			name:   "code is not formatted",
+			source: "`**not bold** <b>`",
+			want:   "<p><code>**not bold** &lt;b&gt;</code></p>\n",
+		},
+		{
+			name:   "link",
+			source: "[site](https://example.com)",
+			want:   `<p><a href="https://example.com" rel="nofollow noopener">site</a></p>` + "\n",
+		},
+		{
+			name:   "mailto link",
+			source: "[mail](mailto:a@example.com)",
+			want:   `<p><a href="mailto:a@example.com" rel="nofollow noopener">mail</a></p>` + "\n",
+		},
+		{
+			name:   "javascript link",
+			source: "[click](javascript:alert(1))",
+			want:   "<p>click)</p>\n",
+		},
+		{
+			name:   "javascript link in upper case",
+			source: "[click](JavaScript:alert)",
+			want:   "<p>click</p>\n",
+		},
+		{
+			name:   "data link",
+			source: "[click](data:text/html;base64,PHNjcmlwdD4=)",
+			want:   "<p>click</p>\n",
+		},
+		{
+			name:   "quotes in href",
+			source: `[x](https://a"onmouseover="alert(1))`,
+			want:   `<p><a href="https://a&#34;onmouseover=&#34;alert(1" rel="nofollow noopener">x</a>)</p>` + "\n",
+		},
+		{
+			name:   "code in link label",
+			source: "[`x`](https://a)",
+			want:   `<p><a href="https://a" rel="nofollow noopener"><code>x</code></a></p>` + "\n",
+		},
+		{
+			name:   "code and emphasis in link label",
+			source: "see [**the** `x` docs](https://a) and `y`",
+			want:   `<p>see <a href="https://a" rel="nofollow noopener"><strong>the</strong> <code>x</code> docs</a> and <code>y</code></p>` + "\n",
+		},
+		{
+			name:   "code in link url",
+			source: "[x](https://a/`b`)",
+			want:   "<p><a href=\"https://a/`b`\" rel=\"nofollow noopener\">x</a></p>\n",
+		},
+		{
+			name:   "code in disallowed link",
+			source: "[`x`](javascript:y)",
+			want:   "<p><code>x</code></p>\n",
+		},
+		{
+			name:   "nul bytes",
+			source: "a\x000\x00b",
+			want:   "<p>a0b</p>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.source))
+			if got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.source, got, tt.want)
+			}
+			if strings.Contains(got, "\x00") {
+				t.Errorf("Render(%q) left a placeholder in %q", tt.source, got)
+			}
+		})
+	}
+}
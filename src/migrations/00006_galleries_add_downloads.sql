@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+ADD COLUMN downloads_enabled BOOLEAN NOT NULL DEFAULT true;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+DROP COLUMN downloads_enabled;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE blobs (
+  sha256 TEXT PRIMARY KEY,
+  path TEXT UNIQUE NOT NULL,
+  size BIGINT NOT NULL,
+  ref_count INT NOT NULL DEFAULT 0
+);
+ALTER TABLE images
+DROP CONSTRAINT images_path_key,
+ADD COLUMN sha256 TEXT REFERENCES blobs (sha256),
+ADD COLUMN phash BIGINT;
+CREATE INDEX images_gallery_id_sha256_idx ON images (gallery_id, sha256);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+DROP COLUMN phash,
+DROP COLUMN sha256,
+ADD CONSTRAINT images_path_key UNIQUE (path);
+DROP TABLE blobs;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN storage_quota BIGINT;
+ALTER TABLE images
+ADD COLUMN size BIGINT NOT NULL DEFAULT 0;
+UPDATE images SET size = blobs.size
+FROM blobs WHERE images.sha256 = blobs.sha256;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+DROP COLUMN size;
+ALTER TABLE users
+DROP COLUMN storage_quota,
+DROP COLUMN is_admin;
+-- +goose StatementEnd
\ No newline at end of file
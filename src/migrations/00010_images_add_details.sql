@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images
+ADD COLUMN position INT NOT NULL DEFAULT 0,
+ADD COLUMN caption TEXT NOT NULL DEFAULT '',
+ADD COLUMN alt_text TEXT NOT NULL DEFAULT '';
+UPDATE images SET position = ordered.position
+FROM (
+  SELECT id, row_number() OVER (PARTITION BY gallery_id ORDER BY id) AS position
+  FROM images
+) ordered
+WHERE images.id = ordered.id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+DROP COLUMN alt_text,
+DROP COLUMN caption,
+DROP COLUMN position;
+-- +goose StatementEnd
\ No newline at end of file
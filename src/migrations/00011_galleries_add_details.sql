@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+ADD COLUMN description TEXT NOT NULL DEFAULT '',
+ADD COLUMN cover_image_id INT REFERENCES images (id) ON DELETE SET NULL,
+ADD COLUMN location TEXT NOT NULL DEFAULT '',
+ADD COLUMN starts_on DATE,
+ADD COLUMN ends_on DATE,
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+DROP COLUMN updated_at,
+DROP COLUMN created_at,
+DROP COLUMN ends_on,
+DROP COLUMN starts_on,
+DROP COLUMN location,
+DROP COLUMN cover_image_id,
+DROP COLUMN description;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,60 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN handle TEXT;
+-- existing users get a handle derived from their email, made unique with
+-- their ID if needed
+UPDATE users SET handle = named.handle
+FROM (
+  SELECT id, CASE
+      WHEN row_number() OVER (PARTITION BY base ORDER BY id) = 1 THEN base
+      ELSE base || '-' || id
+    END AS handle
+  FROM (
+    SELECT id, COALESCE(NULLIF(trim(BOTH '-' FROM regexp_replace(
+      lower(split_part(email, '@', 1)), '[^a-z0-9]+', '-', 'g')), ''), 'user') AS base
+    FROM users
+  ) bases
+) named
+WHERE users.id = named.id;
+ALTER TABLE users
+ALTER COLUMN handle SET NOT NULL,
+ADD CONSTRAINT users_handle_key UNIQUE (handle);
+
+ALTER TABLE galleries
+ADD COLUMN slug TEXT;
+UPDATE galleries SET slug = named.slug
+FROM (
+  SELECT id, CASE
+      WHEN row_number() OVER (PARTITION BY user_id, base ORDER BY id) = 1 THEN base
+      ELSE base || '-' || id
+    END AS slug
+  FROM (
+    SELECT id, user_id, COALESCE(NULLIF(trim(BOTH '-' FROM regexp_replace(
+      lower(COALESCE(title, '')), '[^a-z0-9]+', '-', 'g')), ''), 'gallery') AS base
+    FROM galleries
+  ) bases
+) named
+WHERE galleries.id = named.id;
+ALTER TABLE galleries
+ALTER COLUMN slug SET NOT NULL,
+ADD CONSTRAINT galleries_user_id_slug_key UNIQUE (user_id, slug);
+
+CREATE TABLE gallery_slug_history (
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  slug TEXT NOT NULL,
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (user_id, slug)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE gallery_slug_history;
+ALTER TABLE galleries
+DROP COLUMN slug;
+ALTER TABLE users
+DROP COLUMN handle;
+-- +goose StatementEnd
\ No newline at end of file
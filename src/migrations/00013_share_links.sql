@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+ADD COLUMN unlisted BOOLEAN NOT NULL DEFAULT false;
+CREATE TABLE gallery_share_links (
+  id SERIAL PRIMARY KEY,
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  label TEXT NOT NULL DEFAULT '',
+  expires_at TIMESTAMPTZ,
+  view_count INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX gallery_share_links_gallery_id_idx ON gallery_share_links (gallery_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE gallery_share_links;
+ALTER TABLE galleries
+DROP COLUMN unlisted;
+-- +goose StatementEnd
\ No newline at end of file
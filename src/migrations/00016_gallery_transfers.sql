@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE gallery_transfers (
+  id SERIAL PRIMARY KEY,
+  gallery_id INT UNIQUE NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  from_user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  to_user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE audit_log (
+  id SERIAL PRIMARY KEY,
+  actor_id INT REFERENCES users (id) ON DELETE SET NULL,
+  action TEXT NOT NULL,
+  gallery_id INT REFERENCES galleries (id) ON DELETE SET NULL,
+  details JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX audit_log_gallery_id_idx ON audit_log (gallery_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE audit_log;
+DROP TABLE gallery_transfers;
+-- +goose StatementEnd
\ No newline at end of file
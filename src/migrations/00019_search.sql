@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+  setweight(to_tsvector('english', description), 'B') ||
+  setweight(to_tsvector('english', location), 'C')
+) STORED;
+CREATE INDEX galleries_search_vector_idx ON galleries USING GIN (search_vector);
+
+ALTER TABLE images
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', caption), 'A') ||
+  setweight(to_tsvector('english', alt_text), 'B')
+) STORED;
+CREATE INDEX images_search_vector_idx ON images USING GIN (search_vector);
+
+ALTER TABLE tags
+ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+  to_tsvector('english', replace(name, '-', ' '))
+) STORED;
+CREATE INDEX tags_search_vector_idx ON tags USING GIN (search_vector);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tags
+DROP COLUMN search_vector;
+ALTER TABLE images
+DROP COLUMN search_vector;
+ALTER TABLE galleries
+DROP COLUMN search_vector;
+-- +goose StatementEnd
\ No newline at end of file
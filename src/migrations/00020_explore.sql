@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+ADD COLUMN published_at TIMESTAMPTZ,
+ADD COLUMN view_count BIGINT NOT NULL DEFAULT 0,
+ADD COLUMN discoverable BOOLEAN NOT NULL DEFAULT true;
+UPDATE galleries SET published_at = updated_at WHERE published;
+CREATE INDEX galleries_explore_newest_idx ON galleries (published_at DESC, id DESC)
+  WHERE published AND discoverable AND deleted_at IS NULL AND password_hash IS NULL;
+CREATE INDEX galleries_explore_popular_idx ON galleries (view_count DESC, id DESC)
+  WHERE published AND discoverable AND deleted_at IS NULL AND password_hash IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+DROP COLUMN discoverable,
+DROP COLUMN view_count,
+DROP COLUMN published_at;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+ADD COLUMN avatar_key TEXT UNIQUE,
+ADD COLUMN avatar_path TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP COLUMN avatar_path,
+DROP COLUMN avatar_key,
+DROP COLUMN bio,
+DROP COLUMN display_name;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE follows (
+  follower_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  followee_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (follower_id, followee_id),
+  CHECK (follower_id <> followee_id)
+);
+CREATE INDEX follows_followee_id_idx ON follows (followee_id);
+
+-- the timeline walks the galleries of each followed user by publication
+-- date, and the images of each of those galleries by upload date
+CREATE INDEX galleries_timeline_idx ON galleries (user_id, published_at DESC)
+  WHERE published AND discoverable AND deleted_at IS NULL AND password_hash IS NULL;
+CREATE INDEX images_timeline_idx ON images (gallery_id, created_at DESC)
+  WHERE deleted_at IS NULL;
+
+ALTER TABLE users
+ADD COLUMN digest_enabled BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN digest_sent_at TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP COLUMN digest_sent_at,
+DROP COLUMN digest_enabled;
+DROP INDEX images_timeline_idx;
+DROP INDEX galleries_timeline_idx;
+DROP TABLE follows;
+-- +goose StatementEnd
\ No newline at end of file
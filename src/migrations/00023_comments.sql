@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+ADD COLUMN comments_enabled BOOLEAN NOT NULL DEFAULT true;
+
+CREATE TABLE comments (
+  id SERIAL PRIMARY KEY,
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  image_id INT REFERENCES images (id) ON DELETE CASCADE,
+  parent_id INT REFERENCES comments (id) ON DELETE CASCADE,
+  depth INT NOT NULL DEFAULT 0,
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  body TEXT NOT NULL,
+  hidden_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX comments_gallery_id_idx ON comments (gallery_id, created_at);
+CREATE INDEX comments_image_id_idx ON comments (image_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE comments;
+ALTER TABLE galleries
+DROP COLUMN comments_enabled;
+-- +goose StatementEnd
\ No newline at end of file
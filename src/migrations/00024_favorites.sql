@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE gallery_favorites (
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, gallery_id)
+);
+CREATE TABLE image_favorites (
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  image_id INT NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, image_id)
+);
+CREATE INDEX image_favorites_image_id_idx ON image_favorites (image_id);
+ALTER TABLE galleries
+ADD COLUMN favorite_count INT NOT NULL DEFAULT 0;
+ALTER TABLE images
+ADD COLUMN favorite_count INT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+DROP COLUMN favorite_count;
+ALTER TABLE galleries
+DROP COLUMN favorite_count;
+DROP TABLE image_favorites;
+DROP TABLE gallery_favorites;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+ADD COLUMN proofing_enabled BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN proofing_limit INT NOT NULL DEFAULT 0;
+
+CREATE TABLE proofing_selections (
+  id SERIAL PRIMARY KEY,
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  client_name TEXT NOT NULL DEFAULT '',
+  client_email TEXT NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  submitted_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX proofing_selections_gallery_id_idx ON proofing_selections (gallery_id, submitted_at);
+
+CREATE TABLE proofing_picks (
+  selection_id INT NOT NULL REFERENCES proofing_selections (id) ON DELETE CASCADE,
+  image_id INT NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+  note TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (selection_id, image_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE proofing_picks;
+DROP TABLE proofing_selections;
+ALTER TABLE galleries
+DROP COLUMN proofing_limit,
+DROP COLUMN proofing_enabled;
+-- +goose StatementEnd
\ No newline at end of file
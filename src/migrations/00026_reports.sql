@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN suspended_at TIMESTAMPTZ;
+
+CREATE TABLE reports (
+  id SERIAL PRIMARY KEY,
+  reporter_id INT REFERENCES users (id) ON DELETE SET NULL,
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  image_id INT REFERENCES images (id) ON DELETE CASCADE,
+  comment_id INT REFERENCES comments (id) ON DELETE CASCADE,
+  reason TEXT NOT NULL,
+  details TEXT NOT NULL DEFAULT '',
+  resolution TEXT,
+  resolved_by INT REFERENCES users (id) ON DELETE SET NULL,
+  resolved_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX reports_open_idx ON reports (created_at) WHERE resolved_at IS NULL;
+CREATE UNIQUE INDEX reports_open_unique_idx
+ON reports (reporter_id, gallery_id, COALESCE(image_id, 0), COALESCE(comment_id, 0))
+WHERE resolved_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE reports;
+ALTER TABLE users
+DROP COLUMN suspended_at;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE analytics_salts (
+  day DATE PRIMARY KEY,
+  salt TEXT NOT NULL
+);
+
+CREATE TABLE view_visitors (
+  day DATE NOT NULL,
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  image_id INT REFERENCES images (id) ON DELETE CASCADE,
+  visitor_hash TEXT NOT NULL
+);
+CREATE UNIQUE INDEX view_visitors_unique_idx
+ON view_visitors (day, gallery_id, COALESCE(image_id, 0), visitor_hash);
+
+CREATE TABLE gallery_daily_views (
+  gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+  day DATE NOT NULL,
+  views INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (gallery_id, day)
+);
+
+CREATE TABLE image_daily_views (
+  image_id INT NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+  day DATE NOT NULL,
+  views INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (image_id, day)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE image_daily_views;
+DROP TABLE gallery_daily_views;
+DROP TABLE view_visitors;
+DROP TABLE analytics_salts;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- duplicates that slipped in through concurrent uploads go to the trash, so
+-- their owners can still restore or purge them
+UPDATE images SET deleted_at=now()
+WHERE id IN (
+  SELECT id FROM (
+    SELECT id, row_number() OVER (PARTITION BY gallery_id, sha256 ORDER BY id) AS n
+    FROM images
+    WHERE deleted_at IS NULL AND sha256 IS NOT NULL) d
+  WHERE n > 1);
+CREATE UNIQUE INDEX images_gallery_id_sha256_key ON images (gallery_id, sha256)
+WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX images_gallery_id_sha256_key;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- handles users had before stay reserved for them, so that links to their
+-- profiles and galleries keep working
+CREATE TABLE user_handle_history (
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  handle TEXT UNIQUE NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE user_handle_history;
+-- +goose StatementEnd
\ No newline at end of file
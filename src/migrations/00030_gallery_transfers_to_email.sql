@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- transfers are addressed to an email, which doesn't need to have an account
+-- yet, so that owners can't find out which emails have one
+ALTER TABLE gallery_transfers
+ADD COLUMN to_email TEXT;
+UPDATE gallery_transfers t SET to_email = u.email
+FROM users u
+WHERE u.id = t.to_user_id;
+ALTER TABLE gallery_transfers
+ALTER COLUMN to_email SET NOT NULL,
+DROP COLUMN to_user_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE gallery_transfers
+ADD COLUMN to_user_id INT REFERENCES users (id) ON DELETE CASCADE;
+UPDATE gallery_transfers t SET to_user_id = u.id
+FROM users u
+WHERE u.email = t.to_email;
+DELETE FROM gallery_transfers
+WHERE to_user_id IS NULL;
+ALTER TABLE gallery_transfers
+ALTER COLUMN to_user_id SET NOT NULL,
+DROP COLUMN to_email;
+-- +goose StatementEnd
\ No newline at end of file
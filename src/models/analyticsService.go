@@ -0,0 +1,229 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shamanskiy/lenslocked/src/rand"
+)
+
+// Views are counted once per visitor and day. Visitors are told apart by a
+// hash of what identifies them, usually their IP address and user agent,
+// salted with a random salt that changes every day. The identifying details
+// are never stored, and once the salt of a day is gone, the hashes of that
+// day can't be linked to anyone, not even to the same visitor on other days.
+// Only the hashes and the salt of the current day are kept. Older ones are
+// purged and only the daily counts remain.
+
+// BytesPerAnalyticsSalt is the number of random bytes in the daily salts.
+const BytesPerAnalyticsSalt = 32
+
+// DailyViews is the number of visitors of a gallery on a day.
+type DailyViews struct {
+	Day   time.Time
+	Views int
+}
+
+// ImageViews is an image together with its number of views.
+type ImageViews struct {
+	Image
+	Views int
+}
+
+type AnalyticsService struct {
+	DB *sql.DB
+
+	// the salt of the current day is cached, as it is needed for every view
+	mu      sync.Mutex
+	saltDay time.Time
+	daySalt string
+}
+
+// RecordGalleryView counts the visitor as a viewer of the gallery page
+// unless they viewed it today already, and reports whether the view was
+// counted.
+func (as *AnalyticsService) RecordGalleryView(galleryID int, visitor string) (bool, error) {
+	day := today()
+	hash, err := as.visitorHash(day, visitor)
+	if err != nil {
+		return false, fmt.Errorf("record gallery view: %w", err)
+	}
+	result, err := as.DB.Exec(`
+	  WITH added AS (
+	    INSERT INTO view_visitors (day, gallery_id, visitor_hash) VALUES ($1, $2, $3)
+	    ON CONFLICT DO NOTHING RETURNING 1)
+	  INSERT INTO gallery_daily_views (gallery_id, day, views)
+	  SELECT $2, $1, 1 FROM added
+	  ON CONFLICT (gallery_id, day) DO UPDATE
+	  SET views = gallery_daily_views.views + 1`, day, galleryID, hash)
+	if err != nil {
+		return false, fmt.Errorf("record gallery view: %w", err)
+	}
+	// the daily views are only changed if the visitor was added
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("record gallery view: %w", err)
+	}
+	return changed > 0, nil
+}
+
+// RecordImageView counts the visitor as a viewer of the image in the gallery
+// unless they viewed it today already.
+func (as *AnalyticsService) RecordImageView(galleryID, imageID int, visitor string) error {
+	day := today()
+	hash, err := as.visitorHash(day, visitor)
+	if err != nil {
+		return fmt.Errorf("record image view: %w", err)
+	}
+	_, err = as.DB.Exec(`
+	  WITH added AS (
+	    INSERT INTO view_visitors (day, gallery_id, image_id, visitor_hash) VALUES ($1, $2, $3, $4)
+	    ON CONFLICT DO NOTHING RETURNING 1)
+	  INSERT INTO image_daily_views (image_id, day, views)
+	  SELECT $3, $1, 1 FROM added
+	  ON CONFLICT (image_id, day) DO UPDATE
+	  SET views = image_daily_views.views + 1`, day, galleryID, imageID, hash)
+	if err != nil {
+		return fmt.Errorf("record image view: %w", err)
+	}
+	return nil
+}
+
+// DailyViews returns the views of the gallery on each of the last days,
+// oldest first and including today. Days without views are included with 0
+// views.
+func (as *AnalyticsService) DailyViews(galleryID, days int) ([]DailyViews, error) {
+	end := today()
+	start := end.AddDate(0, 0, 1-days)
+	rows, err := as.DB.Query(`
+	  SELECT day, views FROM gallery_daily_views
+	  WHERE gallery_id=$1 AND day >= $2`, galleryID, start)
+	if err != nil {
+		return nil, fmt.Errorf("daily views: %w", err)
+	}
+	defer rows.Close()
+
+	views := map[time.Time]int{}
+	for rows.Next() {
+		var day time.Time
+		var count int
+		err := rows.Scan(&day, &count)
+		if err != nil {
+			return nil, fmt.Errorf("daily views: %w", err)
+		}
+		views[truncateDay(day)] = count
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("daily views: %w", rows.Err())
+	}
+
+	result := make([]DailyViews, 0, days)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		result = append(result, DailyViews{Day: day, Views: views[day]})
+	}
+	return result, nil
+}
+
+// TopImages returns up to limit images of the gallery with the most views on
+// the last days, the most viewed first.
+func (as *AnalyticsService) TopImages(galleryID, days, limit int) ([]ImageViews, error) {
+	start := today().AddDate(0, 0, 1-days)
+	rows, err := as.DB.Query(`
+	  SELECT i.id, i.gallery_id, i.key, i.filename, i.caption, i.alt_text,
+	    i.favorite_count, sum(v.views) AS total
+	  FROM image_daily_views v JOIN images i ON i.id = v.image_id
+	  WHERE i.gallery_id=$1 AND i.deleted_at IS NULL AND v.day >= $2
+	  GROUP BY i.id
+	  ORDER BY total DESC, i.position, i.id
+	  LIMIT $3`, galleryID, start, limit)
+	if err != nil {
+		return nil, fmt.Errorf("top images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []ImageViews
+	for rows.Next() {
+		var image ImageViews
+		err := rows.Scan(&image.ID, &image.GalleryID, &image.Key, &image.Filename,
+			&image.Caption, &image.AltText, &image.FavoriteCount, &image.Views)
+		if err != nil {
+			return nil, fmt.Errorf("top images: %w", err)
+		}
+		images = append(images, image)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("top images: %w", rows.Err())
+	}
+	return images, nil
+}
+
+// PurgeVisitors deletes the visitor hashes and salts of past days, which are
+// no longer needed to count views.
+func (as *AnalyticsService) PurgeVisitors() error {
+	before := today()
+	_, err := as.DB.Exec(`
+	  DELETE FROM view_visitors WHERE day < $1`, before)
+	if err != nil {
+		return fmt.Errorf("purge visitors: %w", err)
+	}
+	_, err = as.DB.Exec(`
+	  DELETE FROM analytics_salts WHERE day < $1`, before)
+	if err != nil {
+		return fmt.Errorf("purge visitors: %w", err)
+	}
+	return nil
+}
+
+// visitorHash hashes the visitor with the salt of the day.
+func (as *AnalyticsService) visitorHash(day time.Time, visitor string) (string, error) {
+	salt, err := as.salt(day)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(salt + visitor))
+	return base64.URLEncoding.EncodeToString(hash[:]), nil
+}
+
+// salt returns the salt of the day, creating it if needed. The salt is
+// stored, so all servers and restarts use the same salt on the same day.
+func (as *AnalyticsService) salt(day time.Time) (string, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	if as.saltDay.Equal(day) {
+		return as.daySalt, nil
+	}
+
+	salt, err := rand.String(BytesPerAnalyticsSalt)
+	if err != nil {
+		return "", err
+	}
+	_, err = as.DB.Exec(`
+	  INSERT INTO analytics_salts (day, salt) VALUES ($1, $2)
+	  ON CONFLICT DO NOTHING`, day, salt)
+	if err != nil {
+		return "", err
+	}
+	err = as.DB.QueryRow(`
+	  SELECT salt FROM analytics_salts WHERE day=$1`, day).Scan(&salt)
+	if err != nil {
+		return "", err
+	}
+	as.saltDay = day
+	as.daySalt = salt
+	return salt, nil
+}
+
+// today returns the start of the current day in UTC, which is the day views
+// are counted for.
+func today() time.Time {
+	return truncateDay(time.Now())
+}
+
+func truncateDay(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
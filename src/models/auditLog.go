@@ -0,0 +1,28 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditGalleryTransferred = "gallery.transferred"
+)
+
+// recordAudit adds an entry to the audit log as part of the transaction, so
+// the entry is only kept if the audited change is committed.
+func recordAudit(tx *sql.Tx, actorID int, action string, galleryID int, details map[string]any) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("record audit: %w", err)
+	}
+	_, err = tx.Exec(`
+	  INSERT INTO audit_log (actor_id, action, gallery_id, details)
+	  VALUES ($1, $2, $3, $4)`, nullID(actorID), action, nullID(galleryID), string(detailsJSON))
+	if err != nil {
+		return fmt.Errorf("record audit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/Shamanskiy/lenslocked/src/rand"
+)
+
+// Image contents are stored once per unique SHA-256 in the blobs directory
+// and shared by all images with the same contents. Each blob keeps a count of
+// the images referencing it and is removed from disk when the last one goes.
+
+const blobsDirName = "blobs"
+
+// writeTempBlob copies contents into a temporary file in the blobs directory
+// and returns its path together with the SHA-256 and size of the contents.
+func (service *GalleryService) writeTempBlob(contents io.Reader) (tmpPath, hash string, size int64, err error) {
+	blobsDir := filepath.Join(service.imagesDir(), blobsDirName)
+	err = os.MkdirAll(blobsDir, 0755)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("creating blobs directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(blobsDir, "upload-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("creating temporary blob: %w", err)
+	}
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, hasher), contents)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", 0, fmt.Errorf("writing temporary blob: %w", err)
+	}
+	return tmp.Name(), hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// blobFiles tracks the blob files changed within a transaction, so the files
+// on disk can follow the outcome of the transaction. New blobs are moved
+// into place right away and removed files are only renamed, while the rows
+// of their blobs are locked. Call commit after the transaction commits, and
+// rollback otherwise. Once committed, rollback does nothing, so it can be
+// deferred.
+type blobFiles struct {
+	// added are the paths of new blob files.
+	added []string
+	// removed maps the renamed paths of removed files to their original
+	// paths.
+	removed   map[string]string
+	committed bool
+}
+
+// remove renames the file out of the way. It is deleted for good by commit
+// and put back by rollback.
+func (files *blobFiles) remove(path string) error {
+	suffix, err := rand.String(8)
+	if err != nil {
+		return err
+	}
+	removedPath := path + ".removed-" + suffix
+	err = os.Rename(path, removedPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if files.removed == nil {
+		files.removed = make(map[string]string)
+	}
+	files.removed[removedPath] = path
+	return nil
+}
+
+// commit deletes the removed files for good.
+func (files *blobFiles) commit() error {
+	files.committed = true
+	var firstErr error
+	for removedPath := range files.removed {
+		err := os.Remove(removedPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && firstErr == nil {
+			firstErr = fmt.Errorf("remove blob file: %w", err)
+		}
+	}
+	return firstErr
+}
+
+// rollback deletes the new blob files and puts the removed files back.
+func (files *blobFiles) rollback() {
+	if files.committed {
+		return
+	}
+	for _, path := range files.added {
+		os.Remove(path)
+	}
+	for removedPath, path := range files.removed {
+		os.Rename(removedPath, path)
+	}
+}
+
+// claimBlob adds a reference to the blob with the given hash and returns its
+// path relative to ImagesDir. If the blob doesn't exist yet, the temporary
+// file becomes the blob, otherwise the temporary file is removed. A new blob
+// file is tracked in files, so it is removed again if the transaction
+// doesn't commit.
+func (service *GalleryService) claimBlob(tx *sql.Tx, files *blobFiles, tmpPath, hash, ext string, size int64) (string, error) {
+	path := blobsDirName + "/" + hash[:2] + "/" + hash + ext
+	var refCount int
+	row := tx.QueryRow(`
+	  INSERT INTO blobs (sha256, path, size, ref_count)
+	  VALUES ($1, $2, $3, 1) ON CONFLICT (sha256) DO
+	  UPDATE SET ref_count = blobs.ref_count + 1
+	  RETURNING path, ref_count`, hash, path, size)
+	err := row.Scan(&path, &refCount)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("claim blob: %w", err)
+	}
+
+	if refCount > 1 {
+		os.Remove(tmpPath)
+		return path, nil
+	}
+
+	// the new blob row stays locked until the transaction ends, so no one
+	// else can claim or release the blob before the file is in place
+	blobPath := service.imagePath(path)
+	err = os.MkdirAll(filepath.Dir(blobPath), 0755)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("claim blob: %w", err)
+	}
+	err = os.Rename(tmpPath, blobPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("claim blob: %w", err)
+	}
+	files.added = append(files.added, blobPath)
+	return path, nil
+}
+
+// releaseBlob drops a reference to the blob and removes it once nothing
+// references it anymore. The file is removed through files while the blob
+// row is still locked, so a concurrent upload of the same contents can't end
+// up pointing at a deleted file, and the file is put back if the
+// transaction doesn't commit.
+func (service *GalleryService) releaseBlob(tx *sql.Tx, files *blobFiles, hash string) error {
+	var path string
+	var refCount int
+	row := tx.QueryRow(`
+	  UPDATE blobs SET ref_count = ref_count - 1
+	  WHERE sha256=$1
+	  RETURNING path, ref_count`, hash)
+	err := row.Scan(&path, &refCount)
+	if err != nil {
+		return fmt.Errorf("release blob: %w", err)
+	}
+	if refCount > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+	  DELETE FROM blobs
+	  WHERE sha256=$1`, hash)
+	if err != nil {
+		return fmt.Errorf("release blob: %w", err)
+	}
+	err = files.remove(service.imagePath(path))
+	if err != nil {
+		return fmt.Errorf("release blob: %w", err)
+	}
+	return nil
+}
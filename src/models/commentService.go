@@ -0,0 +1,225 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxCommentLength limits comment bodies in characters.
+	MaxCommentLength = 2000
+	// MaxCommentDepth is the deepest level of replies. Comments at this
+	// depth can't be replied to, which keeps threads readable.
+	MaxCommentDepth = 4
+)
+
+type Comment struct {
+	ID        int
+	GalleryID int
+	// ImageID is the image the comment is about, or 0 for comments on the
+	// gallery itself.
+	ImageID int
+	// ParentID is the comment this one replies to, or 0.
+	ParentID   int
+	Depth      int
+	UserID     int
+	UserHandle string
+	// Body is written in Markdown and has to be rendered with the markdown
+	// package before being displayed.
+	Body      string
+	Hidden    bool
+	CreatedAt time.Time
+	Replies   []Comment
+}
+
+type CommentService struct {
+	DB *sql.DB
+}
+
+// Create adds a comment to the gallery, or to one of its images if imageID
+// is not 0. Replies have to be about the same gallery or image as their
+// parent, and can't be deeper than MaxCommentDepth.
+func (cs *CommentService) Create(galleryID, imageID, parentID, userID int, body string) (*Comment, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > MaxCommentLength {
+		return nil, ErrInvalidComment
+	}
+
+	comment := Comment{
+		GalleryID: galleryID,
+		ImageID:   imageID,
+		ParentID:  parentID,
+		UserID:    userID,
+		Body:      body,
+	}
+	if parentID != 0 {
+		parent, err := cs.Find(galleryID, parentID)
+		if err != nil {
+			return nil, fmt.Errorf("create comment: %w", err)
+		}
+		if parent.ImageID != imageID || parent.Hidden || parent.Depth >= MaxCommentDepth {
+			return nil, fmt.Errorf("create comment: %w", ErrCommentNotFound)
+		}
+		comment.Depth = parent.Depth + 1
+	}
+
+	row := cs.DB.QueryRow(`
+	  INSERT INTO comments (gallery_id, image_id, parent_id, depth, user_id, body)
+	  VALUES ($1, $2, $3, $4, $5, $6)
+	  RETURNING id, created_at`, galleryID, nullID(imageID), nullID(parentID),
+		comment.Depth, userID, body)
+	err := row.Scan(&comment.ID, &comment.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create comment: %w", err)
+	}
+	return &comment, nil
+}
+
+// Find returns the comment of the gallery with the ID, or ErrCommentNotFound.
+func (cs *CommentService) Find(galleryID, commentID int) (*Comment, error) {
+	rows, err := cs.DB.Query(`
+	  SELECT `+commentColumns+`
+	  FROM comments c JOIN users u ON u.id = c.user_id
+	  WHERE c.gallery_id=$1 AND c.id=$2`, galleryID, commentID)
+	if err != nil {
+		return nil, fmt.Errorf("find comment: %w", err)
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, fmt.Errorf("find comment: %w", err)
+	}
+	if len(comments) == 0 {
+		return nil, ErrCommentNotFound
+	}
+	return &comments[0], nil
+}
+
+// Thread returns the comments on the gallery, or on one of its images if
+// imageID is not 0, as a tree of replies in the order they were written.
+// Hidden comments and the replies to them are left out unless withHidden is
+// true.
+func (cs *CommentService) Thread(galleryID, imageID int, withHidden bool) ([]Comment, error) {
+	rows, err := cs.DB.Query(`
+	  SELECT `+commentColumns+`
+	  FROM comments c JOIN users u ON u.id = c.user_id
+	  WHERE c.gallery_id=$1 AND c.image_id IS NOT DISTINCT FROM $2
+	  ORDER BY c.created_at, c.id`, galleryID, nullID(imageID))
+	if err != nil {
+		return nil, fmt.Errorf("comment thread: %w", err)
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, fmt.Errorf("comment thread: %w", err)
+	}
+
+	replies := map[int][]Comment{}
+	for _, comment := range comments {
+		if comment.Hidden && !withHidden {
+			continue
+		}
+		replies[comment.ParentID] = append(replies[comment.ParentID], comment)
+	}
+	return commentTree(replies, 0), nil
+}
+
+// commentTree assembles the replies to the parent, recursively.
+func commentTree(replies map[int][]Comment, parentID int) []Comment {
+	thread := replies[parentID]
+	for i := range thread {
+		thread[i].Replies = commentTree(replies, thread[i].ID)
+	}
+	return thread
+}
+
+// ImageCommentCounts returns the number of visible comments on each image of
+// the gallery by image ID.
+func (cs *CommentService) ImageCommentCounts(galleryID int) (map[int]int, error) {
+	rows, err := cs.DB.Query(`
+	  SELECT image_id, count(*) FROM comments
+	  WHERE gallery_id=$1 AND image_id IS NOT NULL AND hidden_at IS NULL
+	  GROUP BY image_id`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("image comment counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var imageID, count int
+		err := rows.Scan(&imageID, &count)
+		if err != nil {
+			return nil, fmt.Errorf("image comment counts: %w", err)
+		}
+		counts[imageID] = count
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("image comment counts: %w", rows.Err())
+	}
+	return counts, nil
+}
+
+// SetHidden hides or shows the comment. Hidden comments are only visible to
+// those who can moderate the gallery.
+func (cs *CommentService) SetHidden(galleryID, commentID int, hidden bool) error {
+	result, err := cs.DB.Exec(`
+	  UPDATE comments
+	  SET hidden_at=CASE WHEN $3 THEN COALESCE(hidden_at, now()) END
+	  WHERE gallery_id=$1 AND id=$2`, galleryID, commentID, hidden)
+	if err != nil {
+		return fmt.Errorf("set comment hidden: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("set comment hidden: %w", err)
+	}
+	if affected == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
+}
+
+// Delete removes the comment together with all replies to it.
+func (cs *CommentService) Delete(galleryID, commentID int) error {
+	result, err := cs.DB.Exec(`
+	  DELETE FROM comments
+	  WHERE gallery_id=$1 AND id=$2`, galleryID, commentID)
+	if err != nil {
+		return fmt.Errorf("delete comment: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete comment: %w", err)
+	}
+	if affected == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
+}
+
+// commentColumns lists the columns scanned by scanComments. Queries using it
+// have to select from comments c joined with users u.
+const commentColumns = `
+	c.id, c.gallery_id, COALESCE(c.image_id, 0), COALESCE(c.parent_id, 0), c.depth,
+	c.user_id, u.handle, c.body, c.hidden_at IS NOT NULL, c.created_at`
+
+func scanComments(rows *sql.Rows) ([]Comment, error) {
+	defer rows.Close()
+	var comments []Comment
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&comment.ID, &comment.GalleryID, &comment.ImageID,
+			&comment.ParentID, &comment.Depth, &comment.UserID, &comment.UserHandle,
+			&comment.Body, &comment.Hidden, &comment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return comments, nil
+}
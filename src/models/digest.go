@@ -0,0 +1,129 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultDigestInterval is how often users get the timeline digest.
+	DefaultDigestInterval = 7 * 24 * time.Hour
+	// digestEntryLimit is the maximum number of timeline entries in a digest.
+	digestEntryLimit = 20
+)
+
+// SetDigest turns the timeline digest email of the user on or off.
+func (us *UserService) SetDigest(userID int, enabled bool) error {
+	_, err := us.DB.Exec(`
+	  UPDATE users
+	  SET digest_enabled=$2
+	  WHERE id=$1`, userID, enabled)
+	if err != nil {
+		return fmt.Errorf("set digest: %w", err)
+	}
+	return nil
+}
+
+// DigestService emails users who enabled the digest what was published by
+// the people they follow.
+type DigestService struct {
+	DB            *sql.DB
+	FollowService *FollowService
+	EmailService  *EmailService
+	// BaseURL is prepended to the paths of links in the emails.
+	BaseURL string
+	// Interval is the time between two digests of a user. Defaults to
+	// DefaultDigestInterval.
+	Interval time.Duration
+}
+
+func (ds *DigestService) interval() time.Duration {
+	if ds.Interval <= 0 {
+		return DefaultDigestInterval
+	}
+	return ds.Interval
+}
+
+type digestRecipient struct {
+	ID    int
+	Email string
+	Since time.Time
+}
+
+// SendDue sends the digest to every user whose last digest is at least an
+// interval ago. Users without news are skipped until the next interval. A
+// failed email doesn't stop the others and is retried on the next run.
+func (ds *DigestService) SendDue() error {
+	due := time.Now().Add(-ds.interval())
+	rows, err := ds.DB.Query(`
+	  SELECT id, email, COALESCE(digest_sent_at, $1)
+	  FROM users
+	  WHERE digest_enabled AND (digest_sent_at IS NULL OR digest_sent_at <= $1)`, due)
+	if err != nil {
+		return fmt.Errorf("send digests: %w", err)
+	}
+	var recipients []digestRecipient
+	for rows.Next() {
+		var recipient digestRecipient
+		err := rows.Scan(&recipient.ID, &recipient.Email, &recipient.Since)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("send digests: %w", err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return fmt.Errorf("send digests: %w", rows.Err())
+	}
+
+	var failed int
+	for _, recipient := range recipients {
+		err := ds.send(recipient)
+		if err != nil {
+			fmt.Printf("digest for user %d: %v\n", recipient.ID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("send digests: %d of %d failed", failed, len(recipients))
+	}
+	return nil
+}
+
+func (ds *DigestService) send(recipient digestRecipient) error {
+	sentAt := time.Now()
+	entries, err := ds.FollowService.Timeline(recipient.ID, recipient.Since, digestEntryLimit)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		var items []DigestItem
+		for _, entry := range entries {
+			items = append(items, DigestItem{
+				Text: digestText(entry),
+				URL:  ds.BaseURL + entry.Gallery.Path(),
+			})
+		}
+		err = ds.EmailService.TimelineDigest(recipient.Email, items, ds.BaseURL+"/users/me")
+		if err != nil {
+			return err
+		}
+	}
+	_, err = ds.DB.Exec(`
+	  UPDATE users SET digest_sent_at=$2
+	  WHERE id=$1`, recipient.ID, sentAt)
+	return err
+}
+
+func digestText(entry TimelineEntry) string {
+	if entry.NewImageCount == 0 {
+		return fmt.Sprintf("%s published %s", entry.Gallery.UserHandle, entry.Gallery.Title)
+	}
+	if entry.NewImageCount == 1 {
+		return fmt.Sprintf("%s added a photo to %s", entry.Gallery.UserHandle, entry.Gallery.Title)
+	}
+	return fmt.Sprintf("%s added %d photos to %s",
+		entry.Gallery.UserHandle, entry.NewImageCount, entry.Gallery.Title)
+}
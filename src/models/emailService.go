@@ -2,6 +2,8 @@ package models
 
 import (
 	"fmt"
+	"html"
+	"strings"
 
 	"github.com/go-mail/mail/v2"
 )
@@ -92,3 +94,125 @@ func (es *EmailService) ForgotPassword(to, resetURL string) error {
 	}
 	return nil
 }
+
+func (es *EmailService) GalleryInvitation(to, inviter, galleryTitle, acceptURL string) error {
+	subject := fmt.Sprintf("%s invited you to the gallery %s", inviter, galleryTitle)
+	email := Email{
+		Subject:   subject,
+		To:        to,
+		Plaintext: subject + ". To join the gallery, please visit the following link: " + acceptURL,
+		HTML:      `<p>` + html.EscapeString(subject) + `. To join the gallery, please visit the following link: <a href="` + acceptURL + `">` + acceptURL + `</a></p>`,
+	}
+	err := es.Send(email)
+	if err != nil {
+		return fmt.Errorf("gallery invitation email: %w", err)
+	}
+	return nil
+}
+
+// DigestItem is a line of the timeline digest email.
+type DigestItem struct {
+	Text string
+	URL  string
+}
+
+func (es *EmailService) TimelineDigest(to string, items []DigestItem, settingsURL string) error {
+	subject := "New galleries from people you follow"
+	plaintext := subject + ":\n\n"
+	htmlBody := `<p>` + subject + `:</p><ul>`
+	for _, item := range items {
+		plaintext += "- " + item.Text + ": " + item.URL + "\n"
+		htmlBody += `<li><a href="` + item.URL + `">` + html.EscapeString(item.Text) + `</a></li>`
+	}
+	plaintext += "\nTo stop these emails, change your settings at " + settingsURL
+	htmlBody += `</ul><p>To stop these emails, change your <a href="` + settingsURL + `">settings</a>.</p>`
+	email := Email{
+		Subject:   subject,
+		To:        to,
+		Plaintext: plaintext,
+		HTML:      htmlBody,
+	}
+	err := es.Send(email)
+	if err != nil {
+		return fmt.Errorf("timeline digest email: %w", err)
+	}
+	return nil
+}
+
+func (es *EmailService) GalleryTransfer(to, from, galleryTitle, acceptURL string) error {
+	subject := fmt.Sprintf("%s wants to transfer the gallery %s to you", from, galleryTitle)
+	email := Email{
+		Subject:   subject,
+		To:        to,
+		Plaintext: subject + ". To become its owner, please visit the following link: " + acceptURL,
+		HTML:      `<p>` + html.EscapeString(subject) + `. To become its owner, please visit the following link: <a href="` + acceptURL + `">` + acceptURL + `</a></p>`,
+	}
+	err := es.Send(email)
+	if err != nil {
+		return fmt.Errorf("gallery transfer email: %w", err)
+	}
+	return nil
+}
+
+func (es *EmailService) ProofingSubmitted(to, client, galleryTitle string, count int, reviewURL string) error {
+	subject := fmt.Sprintf("%s selected %d images in %s", client, count, galleryTitle)
+	email := Email{
+		Subject:   subject,
+		To:        to,
+		Plaintext: subject + ". To review the selection, please visit the following link: " + reviewURL,
+		HTML:      `<p>` + html.EscapeString(subject) + `. To review the selection, please visit the following link: <a href="` + reviewURL + `">` + reviewURL + `</a></p>`,
+	}
+	err := es.Send(email)
+	if err != nil {
+		return fmt.Errorf("proofing submitted email: %w", err)
+	}
+	return nil
+}
+
+// ReportResolved lets a reporter know that an admin reviewed their report
+// about the content.
+func (es *EmailService) ReportResolved(to, content string, resolution ReportResolution) error {
+	outcome := "found that it doesn't break our rules"
+	switch resolution {
+	case ResolutionUnpublished:
+		outcome = "unpublished the gallery"
+	case ResolutionSuspended:
+		outcome = "suspended the account responsible for it"
+	}
+	text := fmt.Sprintf("Thank you for reporting %s. We reviewed it and %s.", content, outcome)
+	email := Email{
+		Subject:   "Your report has been reviewed",
+		To:        to,
+		Plaintext: text,
+		HTML:      `<p>` + html.EscapeString(text) + `</p>`,
+	}
+	err := es.Send(email)
+	if err != nil {
+		return fmt.Errorf("report resolved email: %w", err)
+	}
+	return nil
+}
+
+// ContentModerated lets the owner of reported content know which action an
+// admin took because of the report.
+func (es *EmailService) ContentModerated(to, content string, reason ReportReason, resolution ReportResolution) error {
+	subject := "Your gallery has been unpublished"
+	outcome := "We unpublished the gallery. You can publish it again once the content is removed, but repeated violations will get your account suspended."
+	if resolution == ResolutionSuspended {
+		subject = "Your account has been suspended"
+		outcome = "We suspended your account, so you can't sign in and your galleries are no longer public. Please reply to this email if you think this is a mistake."
+	}
+	text := fmt.Sprintf("We received a report about %s for %s and reviewed it. %s",
+		content, strings.ToLower(reason.Label()), outcome)
+	email := Email{
+		Subject:   subject,
+		To:        to,
+		Plaintext: text,
+		HTML:      `<p>` + html.EscapeString(text) + `</p>`,
+	}
+	err := es.Send(email)
+	if err != nil {
+		return fmt.Errorf("content moderated email: %w", err)
+	}
+	return nil
+}
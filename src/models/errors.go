@@ -10,14 +10,43 @@ import (
 
 var (
 	// users
-	ErrEmailTaken    = errors.New("models: email address is already in use")
-	ErrEmailNotFound = errors.New("models: email address is not found")
-	ErrPasswordWrong = errors.New("models: password is wrong")
-	ErrInvalidToken  = errors.New("models: invalid reset password token")
+	ErrEmailTaken     = errors.New("models: email address is already in use")
+	ErrEmailNotFound  = errors.New("models: email address is not found")
+	ErrPasswordWrong  = errors.New("models: password is wrong")
+	ErrInvalidToken   = errors.New("models: invalid reset password token")
+	ErrInvalidHandle  = errors.New("models: handle contains invalid characters")
+	ErrHandleTaken    = errors.New("models: handle is already in use")
+	ErrProfileTooLong = errors.New("models: display name or bio is too long")
+	ErrAvatarNotFound = errors.New("models: avatar is not found")
+	ErrFollowSelf     = errors.New("models: users cannot follow themselves")
+	ErrUserSuspended  = errors.New("models: user is suspended")
 
 	// galleries
 	ErrResourceNotFound = errors.New("models: resource not found")
 	ErrImageNotFound    = errors.New("models: image is not found")
+	ErrDuplicateImage   = errors.New("models: image is already in the gallery")
+	ErrQuotaExceeded    = errors.New("models: storage quota exceeded")
+	ErrInvalidShareLink = errors.New("models: share link is invalid or expired")
+	ErrInvalidCursor    = errors.New("models: invalid cursor")
+
+	// members
+	ErrInvalidRole        = errors.New("models: role is invalid")
+	ErrInvalidInvitation  = errors.New("models: invitation is invalid or expired")
+	ErrInvitationForOther = errors.New("models: invitation is for another email address")
+	ErrInvalidTransfer    = errors.New("models: transfer is invalid or expired")
+
+	// comments
+	ErrCommentNotFound = errors.New("models: comment is not found")
+	ErrInvalidComment  = errors.New("models: comment is empty or too long")
+
+	// proofing
+	ErrSelectionNotFound = errors.New("models: selection is not found")
+	ErrSelectionLimit    = errors.New("models: too many images are selected")
+	ErrInvalidSelection  = errors.New("models: selection is incomplete or too long")
+
+	// reports
+	ErrReportNotFound = errors.New("models: report is not found")
+	ErrInvalidReport  = errors.New("models: report reason is invalid or details are too long")
 )
 
 type FileError struct {
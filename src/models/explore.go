@@ -0,0 +1,123 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExploreSort is the order of galleries on the explore page.
+type ExploreSort string
+
+const (
+	ExploreNewest  ExploreSort = "newest"
+	ExplorePopular ExploreSort = "popular"
+)
+
+// ExplorePage is a page of discoverable galleries. Next is the cursor of the
+// following page, or empty if this is the last page.
+type ExplorePage struct {
+	Galleries []Gallery
+	Next      string
+}
+
+// Explore returns up to limit discoverable galleries in the given order,
+// starting after the cursor. An empty cursor starts at the first page.
+//
+// Cursors encode the sort value and ID of the last gallery on a page, so
+// pages stay consistent while galleries are published or viewed, unlike
+// offsets.
+func (gs *GalleryService) Explore(sort ExploreSort, cursor string, limit int) (*ExplorePage, error) {
+	var sortColumn string
+	switch sort {
+	case ExploreNewest:
+		sortColumn = "g.published_at"
+	case ExplorePopular:
+		sortColumn = "g.view_count"
+	default:
+		return nil, fmt.Errorf("explore: unknown sort %q", sort)
+	}
+
+	condition := "true"
+	args := []any{limit + 1}
+	if cursor != "" {
+		value, id, err := decodeExploreCursor(sort, cursor)
+		if err != nil {
+			return nil, fmt.Errorf("explore: %w", err)
+		}
+		condition = "(" + sortColumn + ", g.id) < ($2, $3)"
+		args = append(args, value, id)
+	}
+
+	rows, err := gs.DB.Query(`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+`
+	  WHERE `+listableGalleryClause+` AND `+condition+`
+	  ORDER BY `+sortColumn+` DESC, g.id DESC
+	  LIMIT $1`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("explore: %w", err)
+	}
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("explore: %w", err)
+	}
+
+	page := ExplorePage{Galleries: galleries}
+	if len(galleries) > limit {
+		page.Galleries = galleries[:limit]
+		page.Next = encodeExploreCursor(sort, page.Galleries[limit-1])
+	}
+	return &page, nil
+}
+
+func encodeExploreCursor(sort ExploreSort, last Gallery) string {
+	var value string
+	switch sort {
+	case ExploreNewest:
+		value = strconv.FormatInt(last.PublishedAt.UnixMicro(), 10)
+	case ExplorePopular:
+		value = strconv.FormatInt(last.ViewCount, 10)
+	}
+	cursor := value + ":" + strconv.Itoa(last.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodeExploreCursor returns the sort value and gallery ID of the cursor.
+// The sort value has the type of the sort column.
+func decodeExploreCursor(sort ExploreSort, cursor string) (any, int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	rawValue, rawID, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return nil, 0, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	value, err := strconv.ParseInt(rawValue, 10, 64)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	if sort == ExploreNewest {
+		return time.UnixMicro(value), id, nil
+	}
+	return value, id, nil
+}
+
+// RecordView counts a view of the gallery. Callers are expected to count
+// each visitor only once a day.
+func (gs *GalleryService) RecordView(galleryID int) error {
+	_, err := gs.DB.Exec(`
+	  UPDATE galleries SET view_count = view_count + 1
+	  WHERE id=$1`, galleryID)
+	if err != nil {
+		return fmt.Errorf("record gallery view: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Favorites are stored per user, while galleries and images keep a count of
+// their favorites so pages don't have to count them on every view. The count
+// is changed by the same statement that adds or removes the favorite, and
+// only if a row was actually added or removed, so concurrent or repeated
+// requests can't make it drift.
+
+// FavoriteImage is an image on the favorites page together with the gallery
+// it belongs to.
+type FavoriteImage struct {
+	Image
+	GalleryTitle string
+	GalleryPath  string
+}
+
+type FavoriteService struct {
+	DB *sql.DB
+}
+
+// favoriteVisibleClause limits favorites to galleries the user can still
+// open without a password: published ones of owners who aren't suspended and
+// the ones they own or are a member of. Queries using it have to select from galleryFrom and pass the
+// user ID as $1.
+const favoriteVisibleClause = `g.deleted_at IS NULL AND (
+	    g.user_id = $1
+	    OR (g.published AND g.password_hash IS NULL AND u.suspended_at IS NULL)
+	    OR EXISTS (SELECT 1 FROM gallery_members m
+	      WHERE m.gallery_id = g.id AND m.user_id = $1))`
+
+// FavoriteGallery adds the gallery to the favorites of the user. Favoriting a
+// gallery twice has no effect.
+func (fs *FavoriteService) FavoriteGallery(userID, galleryID int) error {
+	_, err := fs.DB.Exec(`
+	  WITH added AS (
+	    INSERT INTO gallery_favorites (user_id, gallery_id) VALUES ($1, $2)
+	    ON CONFLICT DO NOTHING RETURNING 1)
+	  UPDATE galleries SET favorite_count = favorite_count + (SELECT count(*) FROM added)
+	  WHERE id=$2`, userID, galleryID)
+	if err != nil {
+		return fmt.Errorf("favorite gallery: %w", err)
+	}
+	return nil
+}
+
+// UnfavoriteGallery removes the gallery from the favorites of the user.
+func (fs *FavoriteService) UnfavoriteGallery(userID, galleryID int) error {
+	_, err := fs.DB.Exec(`
+	  WITH removed AS (
+	    DELETE FROM gallery_favorites WHERE user_id=$1 AND gallery_id=$2
+	    RETURNING 1)
+	  UPDATE galleries SET favorite_count = favorite_count - (SELECT count(*) FROM removed)
+	  WHERE id=$2`, userID, galleryID)
+	if err != nil {
+		return fmt.Errorf("unfavorite gallery: %w", err)
+	}
+	return nil
+}
+
+// FavoriteImage adds the image to the favorites of the user.
+func (fs *FavoriteService) FavoriteImage(userID, imageID int) error {
+	_, err := fs.DB.Exec(`
+	  WITH added AS (
+	    INSERT INTO image_favorites (user_id, image_id) VALUES ($1, $2)
+	    ON CONFLICT DO NOTHING RETURNING 1)
+	  UPDATE images SET favorite_count = favorite_count + (SELECT count(*) FROM added)
+	  WHERE id=$2`, userID, imageID)
+	if err != nil {
+		return fmt.Errorf("favorite image: %w", err)
+	}
+	return nil
+}
+
+// UnfavoriteImage removes the image from the favorites of the user.
+func (fs *FavoriteService) UnfavoriteImage(userID, imageID int) error {
+	_, err := fs.DB.Exec(`
+	  WITH removed AS (
+	    DELETE FROM image_favorites WHERE user_id=$1 AND image_id=$2
+	    RETURNING 1)
+	  UPDATE images SET favorite_count = favorite_count - (SELECT count(*) FROM removed)
+	  WHERE id=$2`, userID, imageID)
+	if err != nil {
+		return fmt.Errorf("unfavorite image: %w", err)
+	}
+	return nil
+}
+
+// IsGalleryFavorite reports whether the user favorited the gallery.
+func (fs *FavoriteService) IsGalleryFavorite(userID, galleryID int) (bool, error) {
+	var favorite bool
+	err := fs.DB.QueryRow(`
+	  SELECT EXISTS (SELECT 1 FROM gallery_favorites
+	    WHERE user_id=$1 AND gallery_id=$2)`, userID, galleryID).Scan(&favorite)
+	if err != nil {
+		return false, fmt.Errorf("is gallery favorite: %w", err)
+	}
+	return favorite, nil
+}
+
+// FavoriteImageIDs returns the IDs of the images in the gallery that the user
+// favorited.
+func (fs *FavoriteService) FavoriteImageIDs(userID, galleryID int) (map[int]bool, error) {
+	rows, err := fs.DB.Query(`
+	  SELECT f.image_id FROM image_favorites f
+	  JOIN images i ON i.id = f.image_id
+	  WHERE f.user_id=$1 AND i.gallery_id=$2`, userID, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("favorite image ids: %w", err)
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("favorite image ids: %w", err)
+		}
+		ids[id] = true
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("favorite image ids: %w", rows.Err())
+	}
+	return ids, nil
+}
+
+// Galleries returns the galleries the user favorited that they can still
+// view, most recently favorited first.
+func (fs *FavoriteService) Galleries(userID int) ([]Gallery, error) {
+	rows, err := fs.DB.Query(`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+`
+	  JOIN gallery_favorites f ON f.gallery_id = g.id AND f.user_id = $1
+	  WHERE `+favoriteVisibleClause+`
+	  ORDER BY f.created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("favorite galleries: %w", err)
+	}
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("favorite galleries: %w", err)
+	}
+	return galleries, nil
+}
+
+// Images returns the images the user favorited that they can still view,
+// most recently favorited first.
+func (fs *FavoriteService) Images(userID int) ([]FavoriteImage, error) {
+	rows, err := fs.DB.Query(`
+	  SELECT i.id, i.gallery_id, i.key, i.filename, i.caption, i.alt_text,
+	    i.favorite_count, g.title, u.handle, g.slug
+	  `+galleryFrom+`
+	  JOIN images i ON i.gallery_id = g.id
+	  JOIN image_favorites f ON f.image_id = i.id AND f.user_id = $1
+	  WHERE i.deleted_at IS NULL AND `+favoriteVisibleClause+`
+	  ORDER BY f.created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("favorite images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []FavoriteImage
+	for rows.Next() {
+		var image FavoriteImage
+		var gallery Gallery
+		err := rows.Scan(&image.ID, &image.GalleryID, &image.Key, &image.Filename,
+			&image.Caption, &image.AltText, &image.FavoriteCount,
+			&gallery.Title, &gallery.UserHandle, &gallery.Slug)
+		if err != nil {
+			return nil, fmt.Errorf("favorite images: %w", err)
+		}
+		image.GalleryTitle = gallery.Title
+		image.GalleryPath = gallery.Path()
+		images = append(images, image)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("favorite images: %w", rows.Err())
+	}
+	return images, nil
+}
+
+// MostFavoritedImages returns up to limit images of the gallery with at
+// least one favorite, the most favorited first.
+func (service *GalleryService) MostFavoritedImages(galleryID, limit int) ([]Image, error) {
+	images, err := service.queryImages(`
+	  WHERE gallery_id=$1 AND deleted_at IS NULL AND favorite_count > 0
+	  ORDER BY favorite_count DESC, position, id
+	  LIMIT $2`, galleryID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("most favorited images: %w", err)
+	}
+	return images, nil
+}
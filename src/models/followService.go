@@ -0,0 +1,185 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimelineImagePreviews is the number of new images shown for a gallery in
+// the timeline.
+const TimelineImagePreviews = 4
+
+// TimelineEntry is either a newly published gallery or a batch of images
+// added to a gallery after it was published.
+type TimelineEntry struct {
+	Gallery Gallery
+	// NewImageKeys are the keys of the newest images added to the gallery,
+	// at most TimelineImagePreviews of them. It is empty if the entry is
+	// about the gallery being published.
+	NewImageKeys []string
+	// NewImageCount is the number of images added in total.
+	NewImageCount int
+	At            time.Time
+}
+
+type FollowService struct {
+	DB *sql.DB
+}
+
+// Follow makes the follower follow the followee. Following someone twice is
+// not an error.
+func (fs *FollowService) Follow(followerID, followeeID int) error {
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	_, err := fs.DB.Exec(`
+	  INSERT INTO follows (follower_id, followee_id)
+	  VALUES ($1, $2) ON CONFLICT DO NOTHING`, followerID, followeeID)
+	if err != nil {
+		return fmt.Errorf("follow: %w", err)
+	}
+	return nil
+}
+
+func (fs *FollowService) Unfollow(followerID, followeeID int) error {
+	_, err := fs.DB.Exec(`
+	  DELETE FROM follows
+	  WHERE follower_id=$1 AND followee_id=$2`, followerID, followeeID)
+	if err != nil {
+		return fmt.Errorf("unfollow: %w", err)
+	}
+	return nil
+}
+
+func (fs *FollowService) IsFollowing(followerID, followeeID int) (bool, error) {
+	var following bool
+	row := fs.DB.QueryRow(`
+	  SELECT EXISTS (
+	    SELECT 1 FROM follows
+	    WHERE follower_id=$1 AND followee_id=$2)`, followerID, followeeID)
+	err := row.Scan(&following)
+	if err != nil {
+		return false, fmt.Errorf("is following: %w", err)
+	}
+	return following, nil
+}
+
+func (fs *FollowService) FollowerCount(userID int) (int, error) {
+	var count int
+	row := fs.DB.QueryRow(`
+	  SELECT count(*) FROM follows
+	  WHERE followee_id=$1`, userID)
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("follower count: %w", err)
+	}
+	return count, nil
+}
+
+// Timeline returns up to limit entries about the listable galleries of the
+// users the user follows since the given time, newest first.
+//
+// Both queries start from the follows of the user and use the partial
+// timeline indexes on galleries and images, so they only touch recent
+// content of followed users no matter how much else there is.
+func (fs *FollowService) Timeline(userID int, since time.Time, limit int) ([]TimelineEntry, error) {
+	published, err := fs.publishedGalleries(userID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("timeline: %w", err)
+	}
+	added, err := fs.addedImages(userID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("timeline: %w", err)
+	}
+
+	entries := append(published, added...)
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].At.After(entries[b].At)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (fs *FollowService) publishedGalleries(userID int, since time.Time, limit int) ([]TimelineEntry, error) {
+	rows, err := fs.DB.Query(`
+	  SELECT `+galleryColumns+`
+	  FROM follows f
+	  JOIN galleries g ON g.user_id = f.followee_id
+	  JOIN users u ON u.id = g.user_id
+	  WHERE f.follower_id=$1 AND g.published_at > $2 AND `+listableGalleryClause+`
+	  ORDER BY g.published_at DESC
+	  LIMIT $3`, userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return nil, err
+	}
+	var entries []TimelineEntry
+	for _, gallery := range galleries {
+		entries = append(entries, TimelineEntry{
+			Gallery: gallery,
+			At:      gallery.PublishedAt,
+		})
+	}
+	return entries, nil
+}
+
+// addedImages groups images added to galleries after they were published by
+// gallery. Images uploaded before publishing are part of the published
+// gallery entry instead.
+func (fs *FollowService) addedImages(userID int, since time.Time, limit int) ([]TimelineEntry, error) {
+	rows, err := fs.DB.Query(`
+	  SELECT `+galleryColumns+`, n.count, n.latest, COALESCE(recent.keys, '')
+	  FROM (
+	    SELECT i.gallery_id, count(*) AS count, max(i.created_at) AS latest
+	    FROM follows f
+	    JOIN galleries g ON g.user_id = f.followee_id
+	    JOIN images i ON i.gallery_id = g.id
+	    WHERE f.follower_id=$1 AND `+listableGalleryClause+`
+	      AND i.deleted_at IS NULL AND i.created_at > g.published_at AND i.created_at > $2
+	    GROUP BY i.gallery_id
+	    ORDER BY latest DESC
+	    LIMIT $3
+	  ) n
+	  JOIN galleries g ON g.id = n.gallery_id
+	  JOIN users u ON u.id = g.user_id
+	  CROSS JOIN LATERAL (
+	    SELECT string_agg(r.key, ' ' ORDER BY r.created_at DESC) AS keys
+	    FROM (
+	      SELECT key, created_at FROM images
+	      WHERE gallery_id = g.id AND deleted_at IS NULL
+	        AND created_at > g.published_at AND created_at > $2
+	      ORDER BY created_at DESC
+	      LIMIT $4
+	    ) r
+	  ) recent
+	  ORDER BY n.latest DESC`, userID, since, limit, TimelineImagePreviews)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TimelineEntry
+	for rows.Next() {
+		var entry TimelineEntry
+		var keys string
+		gallery, err := scanGallery(withExtraColumns(rows, &entry.NewImageCount, &entry.At, &keys))
+		if err != nil {
+			return nil, err
+		}
+		entry.Gallery = *gallery
+		entry.NewImageKeys = strings.Fields(keys)
+		entries = append(entries, entry)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return entries, nil
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const archiveManifestName = "manifest.json"
+
+type archiveManifest struct {
+	Title  string                 `json:"title"`
+	Images []archiveManifestImage `json:"images"`
+}
+
+type archiveManifestImage struct {
+	Filename string `json:"filename"`
+	Caption  string `json:"caption,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+// WriteArchive streams a ZIP archive with all images of the gallery into w.
+// The archive is never stored on disk, so callers should set any response
+// headers before calling it. If withManifest is true, a manifest.json with
+// the gallery title and image metadata is added to the archive.
+func (service *GalleryService) WriteArchive(w io.Writer, gallery Gallery, withManifest bool) error {
+	images, err := service.Images(gallery.ID)
+	if err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+
+	zipWriter := zip.NewWriter(w)
+	manifest := archiveManifest{Title: gallery.Title}
+	usedNames := map[string]bool{archiveManifestName: true}
+	for _, image := range images {
+		name := uniqueArchiveName(image.Filename, usedNames)
+		err = addFileToArchive(zipWriter, image.Path, name)
+		if err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+		manifest.Images = append(manifest.Images, archiveManifestImage{
+			Filename: name,
+			Caption:  image.Caption,
+			AltText:  image.AltText,
+		})
+	}
+
+	if withManifest {
+		manifestWriter, err := zipWriter.Create(archiveManifestName)
+		if err != nil {
+			return fmt.Errorf("write archive manifest: %w", err)
+		}
+		encoder := json.NewEncoder(manifestWriter)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(manifest)
+		if err != nil {
+			return fmt.Errorf("write archive manifest: %w", err)
+		}
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	return nil
+}
+
+// archiveNameReplacer replaces the characters that separate directories or
+// drives in archive entries on any system.
+var archiveNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_")
+
+// uniqueArchiveName makes sure that images uploaded with the same filename
+// don't overwrite each other when the archive is extracted. The name is made
+// safe first, as uploaded filenames may contain paths meant for another
+// system, like ..\..\x.jpg.
+func uniqueArchiveName(filename string, usedNames map[string]bool) string {
+	// leading dots would hide the file or point to a parent directory
+	filename = strings.TrimLeft(archiveNameReplacer.Replace(filename), ". ")
+	if filename == "" {
+		filename = "image"
+	}
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	name := filename
+	for i := 2; usedNames[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	usedNames[strings.ToLower(name)] = true
+	return name
+}
+
+func addFileToArchive(zipWriter *zip.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("add %v to archive: %w", name, err)
+	}
+	defer file.Close()
+
+	modified := time.Now()
+	info, err := file.Stat()
+	if err == nil {
+		modified = info.ModTime()
+	}
+
+	// images are already compressed, so storing them as is saves CPU time
+	// without making the archive noticeably larger
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	}
+	dst, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("add %v to archive: %w", name, err)
+	}
+	_, err = io.Copy(dst, file)
+	if err != nil {
+		return fmt.Errorf("add %v to archive: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shamanskiy/lenslocked/src/rand"
+)
+
+// Clone creates a new unpublished gallery for the user with the details of
+// the source gallery and copies of the given images of the source gallery.
+// Tags of the gallery and of the copied images are copied as well.
+//
+// Copies share the blobs of the original images, so cloning doesn't take up
+// disk space. Images uploaded before deduplication are moved into a blob
+// while being copied. The copies count against the storage quota of the
+// user like any other image, and ErrQuotaExceeded is returned if they don't
+// fit.
+func (gs *GalleryService) Clone(source Gallery, userID int, title string, images []Image) (*Gallery, error) {
+	tx, err := gs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("clone gallery: %w", err)
+	}
+	defer tx.Rollback()
+	var files blobFiles
+	defer files.rollback()
+
+	var size int64
+	for _, image := range images {
+		size += image.Size
+	}
+	err = gs.QuotaService.check(tx, userID, size)
+	if err != nil {
+		return nil, fmt.Errorf("clone gallery: %w", err)
+	}
+
+	slug, err := uniqueSlug(tx, userID, title, 0)
+	if err != nil {
+		return nil, fmt.Errorf("clone gallery: %w", err)
+	}
+	var galleryID int
+	row := tx.QueryRow(`
+	  INSERT INTO galleries (user_id, title, slug, description, location,
+	    starts_on, ends_on, published, downloads_enabled)
+	  SELECT $1, $2, $3, description, location, starts_on, ends_on, false, downloads_enabled
+	  FROM galleries WHERE id=$4
+	  RETURNING id`, userID, title, slug, source.ID)
+	err = row.Scan(&galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("clone gallery: %w", err)
+	}
+
+	coverImageID := 0
+	for i, image := range images {
+		if image.GalleryID != source.ID {
+			return nil, fmt.Errorf("clone gallery: image %v is not in the gallery", image.Key)
+		}
+		path, hash := "", image.SHA256
+		if hash == "" {
+			path, hash, err = gs.blobFromLegacyImage(tx, &files, image)
+			if err != nil {
+				return nil, fmt.Errorf("clone gallery: %w", err)
+			}
+		} else {
+			_, err = tx.Exec(`
+			  UPDATE blobs SET ref_count = ref_count + 1
+			  WHERE sha256=$1`, hash)
+			if err != nil {
+				return nil, fmt.Errorf("clone gallery: %w", err)
+			}
+		}
+
+		key, err := rand.String(BytesPerImageKey)
+		if err != nil {
+			return nil, fmt.Errorf("clone gallery: %w", err)
+		}
+		var id int
+		row := tx.QueryRow(`
+		  INSERT INTO images (gallery_id, key, filename, path, sha256, phash, size,
+		    position, caption, alt_text)
+		  SELECT $1, $2, filename, COALESCE(NULLIF($3, ''), path), $4, phash, size,
+		    $5, caption, alt_text
+		  FROM images WHERE id=$6
+		  RETURNING id`, galleryID, key, path, hash, i+1, image.ID)
+		err = row.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("clone gallery: %w", err)
+		}
+		if image.ID == source.CoverImageID {
+			coverImageID = id
+		}
+		_, err = tx.Exec(`
+		  INSERT INTO image_tags (image_id, tag_id)
+		  SELECT $1, tag_id FROM image_tags WHERE image_id=$2`, id, image.ID)
+		if err != nil {
+			return nil, fmt.Errorf("clone gallery: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+	  INSERT INTO gallery_tags (gallery_id, tag_id)
+	  SELECT $1, tag_id FROM gallery_tags WHERE gallery_id=$2`, galleryID, source.ID)
+	if err != nil {
+		return nil, fmt.Errorf("clone gallery: %w", err)
+	}
+
+	if coverImageID != 0 {
+		_, err = tx.Exec(`
+		  UPDATE galleries SET cover_image_id=$2
+		  WHERE id=$1`, galleryID, coverImageID)
+		if err != nil {
+			return nil, fmt.Errorf("clone gallery: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("clone gallery: %w", err)
+	}
+	files.commit()
+
+	gallery, err := gs.FindByID(galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("clone gallery: %w", err)
+	}
+	return gallery, nil
+}
+
+// blobFromLegacyImage copies an image stored in its gallery directory into a
+// blob and returns the path and hash of the blob. The original file is kept,
+// since the legacy image still points to it.
+func (gs *GalleryService) blobFromLegacyImage(tx *sql.Tx, files *blobFiles, image Image) (path, hash string, err error) {
+	file, err := os.Open(image.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("blob from legacy image: %w", err)
+	}
+	defer file.Close()
+
+	tmpPath, hash, size, err := gs.writeTempBlob(file)
+	if err != nil {
+		return "", "", fmt.Errorf("blob from legacy image: %w", err)
+	}
+	ext := strings.ToLower(filepath.Ext(image.Path))
+	path, err = gs.claimBlob(tx, files, tmpPath, hash, ext, size)
+	if err != nil {
+		return "", "", fmt.Errorf("blob from legacy image: %w", err)
+	}
+	return path, hash, nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SetPassword protects the gallery with the password. An empty password
+// removes the protection.
+func (gs *GalleryService) SetPassword(galleryID int, password string) error {
+	var passwordHash *string
+	if password != "" {
+		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("set gallery password: %w", err)
+		}
+		hash := string(hashedBytes)
+		passwordHash = &hash
+	}
+
+	_, err := gs.DB.Exec(`
+	  UPDATE galleries
+	  SET password_hash=$2, updated_at=now()
+	  WHERE id=$1`, galleryID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("set gallery password: %w", err)
+	}
+	return nil
+}
+
+// CheckPassword returns ErrPasswordWrong if the password doesn't unlock the
+// gallery. Galleries without a password are unlocked by any password.
+func (gs *GalleryService) CheckPassword(gallery Gallery, password string) error {
+	if !gallery.PasswordProtected() {
+		return nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(gallery.PasswordHash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordWrong
+		}
+		return fmt.Errorf("check gallery password: %w", err)
+	}
+	return nil
+}
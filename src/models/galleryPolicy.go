@@ -0,0 +1,75 @@
+package models
+
+// Role describes what a user may do with a gallery. Owners are not stored as
+// members, their role follows from the user ID of the gallery.
+type Role string
+
+const (
+	RoleNone        Role = ""
+	RoleViewer      Role = "viewer"
+	RoleContributor Role = "contributor"
+	RoleEditor      Role = "editor"
+	RoleOwner       Role = "owner"
+)
+
+// MemberRoles are the roles that can be given to gallery members.
+var MemberRoles = []Role{RoleViewer, RoleContributor, RoleEditor}
+
+// Action is something a user can do with a gallery.
+type Action int
+
+const (
+	// ActionView covers viewing and downloading private galleries.
+	ActionView Action = iota
+	ActionUpload
+	// ActionManageImages covers deleting, reordering and describing images.
+	ActionManageImages
+	// ActionEditSettings covers the gallery details, visibility, password
+	// and share links.
+	ActionEditSettings
+	ActionManageMembers
+	// ActionModerateComments covers hiding and deleting comments of others
+	// and turning comments off.
+	ActionModerateComments
+	ActionTransfer
+	ActionDelete
+)
+
+// policy maps every action to the least privileged role allowed to do it.
+var policy = map[Action]Role{
+	ActionView:             RoleViewer,
+	ActionUpload:           RoleContributor,
+	ActionManageImages:     RoleEditor,
+	ActionEditSettings:     RoleEditor,
+	ActionManageMembers:    RoleOwner,
+	ActionModerateComments: RoleEditor,
+	ActionTransfer:         RoleOwner,
+	ActionDelete:           RoleOwner,
+}
+
+var roleRanks = map[Role]int{
+	RoleViewer:      1,
+	RoleContributor: 2,
+	RoleEditor:      3,
+	RoleOwner:       4,
+}
+
+// Can reports whether the role allows the action.
+func (role Role) Can(action Action) bool {
+	minimum, ok := policy[action]
+	if !ok {
+		return false
+	}
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[minimum]
+}
+
+// ValidMemberRole reports whether the role can be given to gallery members.
+func ValidMemberRole(role Role) bool {
+	for _, memberRole := range MemberRoles {
+		if role == memberRole {
+			return true
+		}
+	}
+	return false
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/Shamanskiy/lenslocked/src/rand"
 )
 
 var supportedExtensions = []string{".png", ".jpg", ".jpeg", ".gif"}
@@ -17,10 +19,157 @@ var supportedExtensions = []string{".png", ".jpg", ".jpeg", ".gif"}
 var supporterMimeTypes = []string{"image/png", "image/jpeg", "image/gif"}
 
 type Gallery struct {
-	ID        int
-	UserID    int
-	Title     string
+	ID     int
+	UserID int
+	// UserHandle is the handle of the gallery owner.
+	UserHandle string
+	Title      string
+	// Slug identifies the gallery among the galleries of its owner. It is
+	// derived from the title and changes with it.
+	Slug string
+	// Description is written in Markdown and has to be rendered with the
+	// markdown package before being displayed.
+	Description string
+	Location    string
+	// StartsOn and EndsOn describe when the photos were taken. Both are
+	// optional and zero when not set.
+	StartsOn time.Time
+	EndsOn   time.Time
+	// CoverImageID is the ID of the image chosen as the gallery cover, or 0
+	// if the owner hasn't chosen one.
+	CoverImageID int
+	// CoverKey is the key of the chosen cover image. Galleries without a
+	// chosen cover fall back to their first image, and CoverKey is empty only
+	// if the gallery has no images at all.
+	CoverKey  string
 	Published bool
+	// Unlisted galleries are not published, but can be viewed by anyone with
+	// a valid share link.
+	Unlisted bool
+	// DownloadsEnabled controls whether visitors can download the whole
+	// gallery as a ZIP archive. Owners can always download their galleries.
+	DownloadsEnabled bool
+	// Discoverable published galleries are listed on the explore and tag
+	// pages and found by search. Other published galleries are only
+	// reachable by their URL.
+	Discoverable bool
+	// PublishedAt is when the gallery was last published, or zero if it is
+	// not published.
+	PublishedAt time.Time
+	// ViewCount counts the views of the gallery by visitors other than the
+	// owner.
+	ViewCount int64
+	// CommentsEnabled lets signed in users comment on the gallery and its
+	// images while it is published.
+	CommentsEnabled bool
+	// FavoriteCount is the number of users who favorited the gallery.
+	FavoriteCount int
+	// ProofingEnabled lets visitors select images for the owner, for
+	// example clients picking the shots to be retouched.
+	ProofingEnabled bool
+	// ProofingLimit is the maximum number of images in a selection, or 0
+	// for no limit.
+	ProofingLimit int
+	// OwnerSuspended is set if the owner was suspended by an admin. Their
+	// galleries can't be viewed by the public while they are suspended.
+	OwnerSuspended bool
+	// PasswordHash is the bcrypt hash of the gallery password, or empty if
+	// the gallery is not password protected.
+	PasswordHash string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	// DeletedAt is set while the gallery is in the trash and zero otherwise.
+	DeletedAt time.Time
+}
+
+// PasswordProtected reports whether visitors have to enter a password to view
+// the gallery.
+func (gallery Gallery) PasswordProtected() bool {
+	return gallery.PasswordHash != ""
+}
+
+// Path returns the public URL path of the gallery.
+func (gallery Gallery) Path() string {
+	return "/u/" + gallery.UserHandle + "/" + gallery.Slug
+}
+
+// galleryColumns lists the columns scanned by scanGallery. Queries using it
+// have to select from galleryFrom.
+const galleryColumns = `
+	g.id, g.user_id, u.handle, g.title, g.slug, g.description, g.location, g.starts_on, g.ends_on,
+	COALESCE(g.cover_image_id, 0),
+	COALESCE(
+	  (SELECT key FROM images
+	    WHERE id = g.cover_image_id AND gallery_id = g.id AND deleted_at IS NULL),
+	  (SELECT key FROM images
+	    WHERE gallery_id = g.id AND deleted_at IS NULL ORDER BY position, id LIMIT 1),
+	  ''),
+	g.published, g.unlisted, g.downloads_enabled, COALESCE(g.password_hash, ''),
+	g.created_at, g.updated_at, g.deleted_at,
+	g.discoverable, g.published_at, g.view_count, g.comments_enabled,
+	g.favorite_count, g.proofing_enabled, g.proofing_limit, u.suspended_at IS NOT NULL`
+
+// galleryFrom joins the tables needed by galleryColumns.
+const galleryFrom = `
+	FROM galleries g JOIN users u ON u.id = g.user_id`
+
+// listableGalleryClause limits queries using galleryFrom to galleries that
+// may show up in public listings. Password protected galleries are left out,
+// since listing them would reveal their images, and so are galleries whose
+// owners opted out of discovery or were suspended.
+const listableGalleryClause = `
+	g.published AND g.discoverable AND g.deleted_at IS NULL AND g.password_hash IS NULL
+	AND NOT EXISTS (SELECT 1 FROM users su
+	  WHERE su.id = g.user_id AND su.suspended_at IS NOT NULL)`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// withExtraColumns lets scanGallery scan rows that select more columns after
+// galleryColumns. The extra columns are scanned into extra.
+func withExtraColumns(row scanner, extra ...any) scanner {
+	return extraColumnsScanner{row: row, extra: extra}
+}
+
+type extraColumnsScanner struct {
+	row   scanner
+	extra []any
+}
+
+func (s extraColumnsScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+func scanGallery(row scanner) (*Gallery, error) {
+	var gallery Gallery
+	var startsOn, endsOn, deletedAt, publishedAt sql.NullTime
+	err := row.Scan(&gallery.ID, &gallery.UserID, &gallery.UserHandle,
+		&gallery.Title, &gallery.Slug, &gallery.Description, &gallery.Location, &startsOn, &endsOn,
+		&gallery.CoverImageID, &gallery.CoverKey,
+		&gallery.Published, &gallery.Unlisted, &gallery.DownloadsEnabled,
+		&gallery.PasswordHash, &gallery.CreatedAt, &gallery.UpdatedAt, &deletedAt,
+		&gallery.Discoverable, &publishedAt, &gallery.ViewCount, &gallery.CommentsEnabled,
+		&gallery.FavoriteCount, &gallery.ProofingEnabled, &gallery.ProofingLimit,
+		&gallery.OwnerSuspended)
+	if err != nil {
+		return nil, err
+	}
+	gallery.StartsOn = startsOn.Time
+	gallery.EndsOn = endsOn.Time
+	gallery.DeletedAt = deletedAt.Time
+	gallery.PublishedAt = publishedAt.Time
+	return &gallery, nil
+}
+
+// nullTime stores zero times and dates as NULL.
+func nullTime(date time.Time) sql.NullTime {
+	return sql.NullTime{Time: date, Valid: !date.IsZero()}
+}
+
+// nullID stores zero IDs as NULL, which is needed for optional foreign keys.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
 }
 
 type GalleryService struct {
@@ -30,42 +179,93 @@ type GalleryService struct {
 	// images. If not set, the GalleryService will default to using the "images"
 	// directory.
 	ImagesDir string
+
+	// TrashRetention is how long deleted galleries and images stay in the
+	// trash. Defaults to DefaultTrashRetention.
+	TrashRetention time.Duration
+
+	// QuotaService keeps new images and clones within the storage quota of
+	// the gallery owner.
+	QuotaService *QuotaService
 }
 
+const (
+	// BytesPerImageKey is the number of random bytes used to generate image
+	// keys. Keys end up in image URLs, so they have to be hard to guess.
+	BytesPerImageKey = 18
+)
+
 type Image struct {
-	Path     string
+	ID        int
+	GalleryID int
+	// Key is a random identifier used in image URLs and to name the stored
+	// file. It never changes once the image is uploaded.
+	Key string
+	// Filename is the original name of the uploaded file. It is only used for
+	// display and never to locate the image on disk.
 	Filename string
+	// Path is the location of the image file on disk. Images with the same
+	// contents share the same file.
+	Path string
+	// SHA256 is the hash of the image contents. It is empty for images
+	// uploaded before deduplication was introduced.
+	SHA256 string
+	// Size of the image contents in bytes.
+	Size int64
+	// Position defines the order of images in the gallery, lowest first.
+	Position int
+	Caption  string
+	// AltText describes the image for screen readers and is shown when the
+	// image cannot be loaded.
+	AltText string
+	// FavoriteCount is the number of users who favorited the image.
+	FavoriteCount int
+	// DeletedAt is set while the image is in the trash and zero otherwise.
+	DeletedAt time.Time
 }
 
-func (gs *GalleryService) Create(userId int, title string) (*Gallery, error) {
-	gallery := Gallery{
-		UserID: userId,
-		Title:  title,
+// Create inserts a new unpublished gallery with the details of the given
+// gallery. The returned gallery has its ID, slug and timestamps set.
+func (gs *GalleryService) Create(details Gallery) (*Gallery, error) {
+	tx, err := gs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
 	}
+	defer tx.Rollback()
 
-	row := gs.DB.QueryRow(`
-	  INSERT INTO galleries (user_id, title, published)
-	  VALUES ($1, $2, false) RETURNING id`,
-		gallery.UserID, gallery.Title)
-	err := row.Scan(&gallery.ID)
+	slug, err := uniqueSlug(tx, details.UserID, details.Title, 0)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
+	}
 
+	row := tx.QueryRow(`
+	  INSERT INTO galleries (user_id, title, slug, description, location,
+	    starts_on, ends_on, published, downloads_enabled)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7, false, true) RETURNING id`,
+		details.UserID, details.Title, slug, details.Description, details.Location,
+		nullTime(details.StartsOn), nullTime(details.EndsOn))
+	var id int
+	err = row.Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
+	}
+	err = tx.Commit()
 	if err != nil {
 		return nil, fmt.Errorf("create gallery: %w", err)
 	}
 
-	return &gallery, nil
+	gallery, err := gs.FindByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("create gallery: %w", err)
+	}
+	return gallery, nil
 }
 
 func (gs *GalleryService) FindByID(id int) (*Gallery, error) {
-	gallery := Gallery{
-		ID: id,
-	}
-
 	row := gs.DB.QueryRow(`
-	  SELECT user_id, title, published
-	  FROM galleries WHERE id=$1`, id)
-	err := row.Scan(&gallery.UserID, &gallery.Title, &gallery.Published)
-
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+` WHERE g.id=$1 AND g.deleted_at IS NULL`, id)
+	gallery, err := scanGallery(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrResourceNotFound
@@ -73,103 +273,306 @@ func (gs *GalleryService) FindByID(id int) (*Gallery, error) {
 		return nil, fmt.Errorf("find gallery by id: %w", err)
 	}
 
-	return &gallery, nil
+	return gallery, nil
 }
 
 func (gs *GalleryService) FindByUserID(userId int) ([]Gallery, error) {
 	rows, err := gs.DB.Query(`
-	  SELECT id, title, published
-	  FROM galleries WHERE user_id=$1`, userId)
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+` WHERE g.user_id=$1 AND g.deleted_at IS NULL`, userId)
 	if err != nil {
 		return nil, fmt.Errorf("find galleries by user_id: %w", err)
 	}
 
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("find galleries by user_id: %w", err)
+	}
+	return galleries, nil
+}
+
+// PublishedByUserID returns the listable galleries of the user, most
+// recently published first. Unlike FindByUserID it never returns private
+// galleries, so it is safe to use for public pages.
+func (gs *GalleryService) PublishedByUserID(userID int) ([]Gallery, error) {
+	rows, err := gs.DB.Query(`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+`
+	  WHERE g.user_id=$1 AND `+listableGalleryClause+`
+	  ORDER BY g.published_at DESC, g.id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("published galleries by user_id: %w", err)
+	}
+
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("published galleries by user_id: %w", err)
+	}
+	return galleries, nil
+}
+
+// FindBySlug looks up a gallery by the handle of its owner and its slug.
+// Slugs the gallery had before its title changed or under previous owners,
+// and previous handles of the owners, are also found, so callers should
+// compare the handle and slug of the returned gallery with the requested ones
+// and redirect if they differ.
+func (gs *GalleryService) FindBySlug(handle, slug string) (*Gallery, error) {
+	row := gs.DB.QueryRow(`
+	  WITH owner AS `+userIDByHandle+`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+`
+	  WHERE g.deleted_at IS NULL AND (
+	    (g.user_id=(SELECT id FROM owner) AND g.slug=$2) OR g.id=(
+	      SELECT h.gallery_id FROM gallery_slug_history h
+	      WHERE h.user_id=(SELECT id FROM owner) AND h.slug=$2))`, handle, slug)
+	gallery, err := scanGallery(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, fmt.Errorf("find gallery by slug: %w", err)
+	}
+
+	return gallery, nil
+}
+
+func scanGalleries(rows *sql.Rows) ([]Gallery, error) {
+	defer rows.Close()
 	galleries := []Gallery{}
 	for rows.Next() {
-		gallery := Gallery{
-			UserID: userId,
-		}
-		err := rows.Scan(&gallery.ID, &gallery.Title, &gallery.Published)
+		gallery, err := scanGallery(rows)
 		if err != nil {
-			return nil, fmt.Errorf("find galleries by user_id: %w", err)
+			return nil, err
 		}
-		galleries = append(galleries, gallery)
+		galleries = append(galleries, *gallery)
 	}
-
 	if rows.Err() != nil {
-		return nil, fmt.Errorf("find galleries by user_id: %w", rows.Err())
+		return nil, rows.Err()
 	}
-
 	return galleries, nil
 }
 
+// Update saves the editable details of the gallery. The cover image is only
+// saved if it belongs to the gallery, otherwise the gallery falls back to its
+// first image. If the new title results in a different slug, the old slug is
+// kept in the history so that old links keep working.
 func (gs *GalleryService) Update(gallery *Gallery) error {
-	_, err := gs.DB.Exec(`
+	tx, err := gs.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldTitle, oldSlug string
+	row := tx.QueryRow(`
+	  SELECT COALESCE(title, ''), slug
+	  FROM galleries WHERE id=$1 FOR UPDATE`, gallery.ID)
+	err = row.Scan(&oldTitle, &oldSlug)
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+
+	gallery.Slug = oldSlug
+	if Slugify(gallery.Title) != Slugify(oldTitle) {
+		gallery.Slug, err = uniqueSlug(tx, gallery.UserID, gallery.Title, gallery.ID)
+		if err != nil {
+			return fmt.Errorf("update gallery: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
 	  UPDATE galleries 
-	  SET title=$1, published=$2
-		WHERE id=$3`, gallery.Title, gallery.Published, gallery.ID)
+	  SET title=$1, slug=$2, published=$3, unlisted=$4, downloads_enabled=$5,
+	    description=$6, location=$7, starts_on=$8, ends_on=$9,
+	    cover_image_id=(SELECT id FROM images
+	      WHERE id=$10 AND gallery_id=$11 AND deleted_at IS NULL),
+	    discoverable=$12, comments_enabled=$13,
+	    proofing_enabled=$14, proofing_limit=$15,
+	    published_at=CASE WHEN NOT $3 THEN NULL ELSE COALESCE(published_at, now()) END,
+	    updated_at=now()
+		WHERE id=$11`, gallery.Title, gallery.Slug, gallery.Published,
+		gallery.Unlisted, gallery.DownloadsEnabled, gallery.Description,
+		gallery.Location, nullTime(gallery.StartsOn), nullTime(gallery.EndsOn),
+		nullID(gallery.CoverImageID), gallery.ID, gallery.Discoverable, gallery.CommentsEnabled,
+		gallery.ProofingEnabled, gallery.ProofingLimit)
+	if err != nil {
+		return fmt.Errorf("update gallery: %w", err)
+	}
+
+	if gallery.Slug != oldSlug {
+		// the new slug may be an old slug of this gallery coming back
+		_, err = tx.Exec(`
+		  DELETE FROM gallery_slug_history
+		  WHERE user_id=$1 AND slug=$2`, gallery.UserID, gallery.Slug)
+		if err != nil {
+			return fmt.Errorf("update gallery: %w", err)
+		}
+		_, err = tx.Exec(`
+		  INSERT INTO gallery_slug_history (user_id, slug, gallery_id)
+		  VALUES ($1, $2, $3)`, gallery.UserID, oldSlug, gallery.ID)
+		if err != nil {
+			return fmt.Errorf("update gallery: %w", err)
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("update gallery: %w", err)
 	}
 	return nil
 }
 
+// uniqueSlug derives a slug from the title that isn't used by any other
+// gallery of the user, including slugs kept in the history for redirects.
+func uniqueSlug(tx *sql.Tx, userID int, title string, galleryID int) (string, error) {
+	base := Slugify(title)
+	if base == "" {
+		base = "gallery"
+	}
+	slug := base
+	for i := 2; ; i++ {
+		var taken bool
+		row := tx.QueryRow(`
+		  SELECT EXISTS (
+		    SELECT 1 FROM galleries WHERE user_id=$1 AND slug=$2 AND id<>$3
+		    UNION ALL
+		    SELECT 1 FROM gallery_slug_history WHERE user_id=$1 AND slug=$2 AND gallery_id<>$3)`,
+			userID, slug, galleryID)
+		err := row.Scan(&taken)
+		if err != nil {
+			return "", fmt.Errorf("unique slug: %w", err)
+		}
+		if !taken {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// Delete moves the gallery to the trash. Trashed galleries keep their images
+// and slug until they are purged, and can be restored until then.
 func (gs *GalleryService) Delete(gallery Gallery) error {
 	_, err := gs.DB.Exec(`
-	  DELETE FROM galleries 
-		WHERE id=$1`, gallery.ID)
+	  UPDATE galleries SET deleted_at=now()
+	  WHERE id=$1 AND deleted_at IS NULL`, gallery.ID)
 	if err != nil {
 		return fmt.Errorf("delete gallery: %w", err)
 	}
-	err = os.RemoveAll(gs.galleryDir(gallery.ID))
+	return nil
+}
+
+// imageHashes returns the blob hashes of all images in the gallery. An image
+// shows up once per reference it holds, so a hash may be listed several times.
+func imageHashes(tx *sql.Tx, galleryID int) ([]string, error) {
+	rows, err := tx.Query(`
+	  SELECT sha256
+	  FROM images WHERE gallery_id=$1 AND sha256 IS NOT NULL`, galleryID)
 	if err != nil {
-		return fmt.Errorf("delete gallery images: %w", err)
+		return nil, fmt.Errorf("image hashes: %w", err)
 	}
-	return nil
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		err := rows.Scan(&hash)
+		if err != nil {
+			return nil, fmt.Errorf("image hashes: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("image hashes: %w", rows.Err())
+	}
+	return hashes, nil
 }
 
 func (service *GalleryService) Images(galleryID int) ([]Image, error) {
-	globPattern := filepath.Join(service.galleryDir(galleryID), "*")
-	allFiles, err := filepath.Glob(globPattern)
+	images, err := service.queryImages(`
+	  WHERE gallery_id=$1 AND deleted_at IS NULL
+	  ORDER BY position, id`, galleryID)
 	if err != nil {
 		return nil, fmt.Errorf("retrieving gallery images: %w", err)
 	}
+	return images, nil
+}
+
+// queryImages selects the images matching the condition, which may also
+// order the images.
+func (service *GalleryService) queryImages(condition string, args ...any) ([]Image, error) {
+	rows, err := service.DB.Query(`
+	  SELECT id, gallery_id, key, filename, path, COALESCE(sha256, ''), size,
+	    position, caption, alt_text, favorite_count, deleted_at
+	  FROM images `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
 	var images []Image
-	for _, filePath := range allFiles {
-		if hasExtension(filePath, supportedExtensions) {
-			images = append(images, Image{
-				Path:     filePath,
-				Filename: filepath.Base(filePath)})
+	for rows.Next() {
+		var image Image
+		var path string
+		var deletedAt sql.NullTime
+		err := rows.Scan(&image.ID, &image.GalleryID, &image.Key, &image.Filename,
+			&path, &image.SHA256, &image.Size, &image.Position, &image.Caption,
+			&image.AltText, &image.FavoriteCount, &deletedAt)
+		if err != nil {
+			return nil, err
 		}
+		image.Path = service.imagePath(path)
+		image.DeletedAt = deletedAt.Time
+		images = append(images, image)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
 	}
-
 	return images, nil
 }
 
-func (service *GalleryService) Image(galleryID int, filename string) (Image, error) {
-	imagePath := filepath.Join(service.galleryDir(galleryID), filename)
+func (service *GalleryService) Image(galleryID int, key string) (Image, error) {
+	image := Image{
+		GalleryID: galleryID,
+		Key:       key,
+	}
 
-	_, err := os.Stat(imagePath)
+	var path string
+	row := service.DB.QueryRow(`
+	  SELECT id, filename, path, COALESCE(sha256, ''), size,
+	    position, caption, alt_text, favorite_count
+	  FROM images WHERE gallery_id=$1 AND key=$2 AND deleted_at IS NULL`, galleryID, key)
+	err := row.Scan(&image.ID, &image.Filename, &path, &image.SHA256, &image.Size,
+		&image.Position, &image.Caption, &image.AltText, &image.FavoriteCount)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
+		if errors.Is(err, sql.ErrNoRows) {
 			return Image{}, ErrImageNotFound
 		}
 		return Image{}, fmt.Errorf("querying for image: %w", err)
 	}
+	image.Path = service.imagePath(path)
 
-	return Image{
-		Filename: filename,
-		Path:     imagePath,
-	}, nil
+	return image, nil
 }
 
-func (service GalleryService) galleryDir(galleryID int) string {
-	imagesDir := service.ImagesDir
-	if imagesDir == "" {
-		imagesDir = "images"
+func (service GalleryService) imagesDir() string {
+	if service.ImagesDir == "" {
+		return "images"
 	}
-	return filepath.Join(imagesDir, fmt.Sprintf("gallery-%d", galleryID))
+	return service.ImagesDir
+}
+
+func (service GalleryService) galleryDir(galleryID int) string {
+	return filepath.Join(service.imagesDir(), galleryDirName(galleryID))
+}
+
+func galleryDirName(galleryID int) string {
+	return fmt.Sprintf("gallery-%d", galleryID)
+}
+
+// imagePath converts a path stored in the images table, which is relative
+// to ImagesDir, into a path on disk.
+func (service GalleryService) imagePath(path string) string {
+	return filepath.Join(service.imagesDir(), filepath.FromSlash(path))
 }
 
 func hasExtension(file string, extensions []string) bool {
@@ -183,42 +586,195 @@ func hasExtension(file string, extensions []string) bool {
 	return false
 }
 
-func (service *GalleryService) DeleteImage(galleryID int, filename string) error {
-	image, err := service.Image(galleryID, filename)
+// DeleteImage moves the image to the trash. The image keeps its blob until
+// it is purged, and can be restored until then.
+func (service *GalleryService) DeleteImage(galleryID int, key string) error {
+	_, err := service.DB.Exec(`
+	  UPDATE images SET deleted_at=now()
+	  WHERE gallery_id=$1 AND key=$2 AND deleted_at IS NULL`, galleryID, key)
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
-	err = os.Remove(image.Path)
+	return nil
+}
+
+// CreateImage stores the uploaded image under a newly generated random key.
+// The original filename is kept only as display metadata, so uploading
+// several files with the same name never overwrites existing images.
+//
+// The contents are stored in a blob shared by all images with the same
+// SHA-256. Uploading contents that are already in the gallery returns
+// ErrDuplicateImage, and uploads that would take the gallery owner over
+// their storage quota return ErrQuotaExceeded.
+func (service *GalleryService) CreateImage(galleryID int, filename string, contents io.ReadSeeker) (*Image, error) {
+	err := validateImageFile(filename, contents)
 	if err != nil {
-		return fmt.Errorf("deleting image: %w", err)
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
 	}
-	return nil
+
+	key, err := rand.String(BytesPerImageKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating image key: %w", err)
+	}
+	image := Image{
+		GalleryID: galleryID,
+		Key:       key,
+		Filename:  filepath.Base(filename),
+	}
+
+	phash := decodePerceptualHash(contents)
+	_, err = contents.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+
+	tmpPath, hash, size, err := service.writeTempBlob(contents)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+	image.SHA256 = hash
+	image.Size = size
+
+	tx, err := service.DB.Begin()
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+	defer tx.Rollback()
+	var files blobFiles
+	defer files.rollback()
+
+	// uploads always count against the storage of the gallery owner, also
+	// when a member uploads them
+	var ownerID int
+	err = tx.QueryRow(`
+	  SELECT user_id FROM galleries WHERE id=$1`, galleryID).Scan(&ownerID)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+	err = service.QuotaService.check(tx, ownerID, size)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	path, err := service.claimBlob(tx, &files, tmpPath, hash, ext, size)
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+	image.Path = service.imagePath(path)
+
+	row := tx.QueryRow(`
+	  INSERT INTO images (gallery_id, key, filename, path, sha256, phash, size, position)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7,
+	    (SELECT COALESCE(MAX(position), 0) + 1 FROM images WHERE gallery_id=$1))
+	  RETURNING id, position`,
+		image.GalleryID, image.Key, image.Filename, path, image.SHA256, phash, size)
+	err = row.Scan(&image.ID, &image.Position)
+	if err != nil {
+		// a unique index keeps the same contents out of the gallery, even
+		// when they are uploaded twice at the same time
+		if isSqlUniqueViolation(err) {
+			err = ErrDuplicateImage
+		}
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("creating image %v: %w", filename, err)
+	}
+	files.commit()
+	return &image, nil
 }
 
-func (service *GalleryService) CreateImage(galleryID int, filename string, contents io.ReadSeeker) error {
-	err := checkContentType(contents, supporterMimeTypes)
+// UpdateImages saves the position, caption and alt text of the given images.
+// Images are matched by key, and keys that don't belong to the gallery are
+// ignored.
+func (service *GalleryService) UpdateImages(galleryID int, images []Image) error {
+	tx, err := service.DB.Begin()
 	if err != nil {
-		return fmt.Errorf("creating image %v: %w", filename, err)
+		return fmt.Errorf("update images: %w", err)
 	}
-	if !hasExtension(filename, supportedExtensions) {
-		return fmt.Errorf("creating image %v: %w", filename, err)
+	defer tx.Rollback()
+
+	for _, image := range images {
+		_, err = tx.Exec(`
+		  UPDATE images
+		  SET position=$3, caption=$4, alt_text=$5
+		  WHERE gallery_id=$1 AND key=$2`,
+			galleryID, image.Key, image.Position, image.Caption, image.AltText)
+		if err != nil {
+			return fmt.Errorf("update images: %w", err)
+		}
 	}
 
-	galleryDir := service.galleryDir(galleryID)
-	err = os.MkdirAll(galleryDir, 0755)
+	err = tx.Commit()
 	if err != nil {
-		return fmt.Errorf("creating gallery-%d images directory: %w", galleryID, err)
+		return fmt.Errorf("update images: %w", err)
 	}
-	imagePath := filepath.Join(galleryDir, filename)
-	dst, err := os.Create(imagePath)
+	return nil
+}
+
+// ImportLegacyImages adds image files that were uploaded before images were
+// tracked in the database. Such files keep their path on disk and their
+// filename, but get a random key like any newly uploaded image.
+func (service *GalleryService) ImportLegacyImages() error {
+	globPattern := filepath.Join(service.imagesDir(), "gallery-*", "*")
+	allFiles, err := filepath.Glob(globPattern)
 	if err != nil {
-		return fmt.Errorf("creating image file: %w", err)
+		return fmt.Errorf("import legacy images: %w", err)
+	}
+
+	for _, filePath := range allFiles {
+		if !hasExtension(filePath, supportedExtensions) {
+			continue
+		}
+		var galleryID int
+		dirName := filepath.Base(filepath.Dir(filePath))
+		_, err := fmt.Sscanf(dirName, "gallery-%d", &galleryID)
+		if err != nil {
+			continue
+		}
+		key, err := rand.String(BytesPerImageKey)
+		if err != nil {
+			return fmt.Errorf("import legacy images: %w", err)
+		}
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return fmt.Errorf("import legacy images: %w", err)
+		}
+
+		filename := filepath.Base(filePath)
+		path := dirName + "/" + filename
+		_, err = service.DB.Exec(`
+		  INSERT INTO images (gallery_id, key, filename, path, size, position)
+		  SELECT id, $2, $3, $4, $5,
+		    (SELECT COALESCE(MAX(position), 0) + 1 FROM images WHERE gallery_id=$1)
+		  FROM galleries WHERE id=$1
+		  AND NOT EXISTS (SELECT 1 FROM images WHERE path=$4)`,
+			galleryID, key, filename, path, info.Size())
+		if err != nil {
+			return fmt.Errorf("import legacy images: %w", err)
+		}
 	}
-	defer dst.Close()
+	return nil
+}
 
-	_, err = io.Copy(dst, contents)
+// validateImageFile returns a FileError unless the file is one of the
+// supported image types, judging by both its contents and its extension.
+func validateImageFile(filename string, contents io.ReadSeeker) error {
+	err := checkContentType(contents, supporterMimeTypes)
 	if err != nil {
-		return fmt.Errorf("copying contents to image: %w", err)
+		return err
+	}
+	if !hasExtension(filename, supportedExtensions) {
+		return FileError{
+			Issue: fmt.Sprintf("invalid extension: %v", filepath.Ext(filename)),
+		}
 	}
 	return nil
 }
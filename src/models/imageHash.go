@@ -0,0 +1,155 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+
+	// register decoders for the supported image formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+const (
+	// DuplicateDistance is the maximum number of differing bits between two
+	// perceptual hashes for the images to be reported as possible duplicates.
+	DuplicateDistance = 10
+
+	hashWidth  = 8
+	hashHeight = 8
+	// number of pixels sampled along each side of a grid cell
+	samplesPerCell = 4
+	// images above this size are not decoded to keep memory usage in check.
+	// Decoded images take 4 to 8 bytes per pixel, and small files can
+	// declare huge dimensions, so the limit has to hold for several uploads
+	// at once.
+	maxHashedPixels = 16_000_000
+)
+
+// PossibleDuplicate is a pair of images from the same gallery that look alike
+// according to their perceptual hashes.
+type PossibleDuplicate struct {
+	Image    Image
+	Other    Image
+	Distance int
+}
+
+// perceptualHash computes a difference hash (dHash) of the image. The image
+// is scaled down to a 9x8 grayscale grid, and every bit of the hash tells if
+// a cell is brighter than its right neighbour. Resized or recompressed
+// copies of the same photo end up with hashes that differ only in a few bits.
+func perceptualHash(img image.Image) uint64 {
+	var grid [hashHeight][hashWidth + 1]float64
+	bounds := img.Bounds()
+	cellWidth := float64(bounds.Dx()) / (hashWidth + 1)
+	cellHeight := float64(bounds.Dy()) / hashHeight
+	for row := 0; row < hashHeight; row++ {
+		for col := 0; col < hashWidth+1; col++ {
+			var sum float64
+			for sy := 0; sy < samplesPerCell; sy++ {
+				for sx := 0; sx < samplesPerCell; sx++ {
+					x := bounds.Min.X + int((float64(col)+(float64(sx)+0.5)/samplesPerCell)*cellWidth)
+					y := bounds.Min.Y + int((float64(row)+(float64(sy)+0.5)/samplesPerCell)*cellHeight)
+					sum += luma(img, x, y)
+				}
+			}
+			grid[row][col] = sum
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < hashHeight; row++ {
+		for col := 0; col < hashWidth; col++ {
+			if grid[row][col] > grid[row][col+1] {
+				hash |= 1 << (row*hashWidth + col)
+			}
+		}
+	}
+	return hash
+}
+
+// decodePerceptualHash decodes the image and returns its perceptual hash. A
+// perceptual hash is nice to have, so images that cannot be decoded or are
+// too large to decode safely are still accepted, just without a hash.
+func decodePerceptualHash(r io.ReadSeeker) sql.NullInt64 {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil || config.Width*config.Height > maxHashedPixels {
+		return sql.NullInt64{}
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(perceptualHash(img)), Valid: true}
+}
+
+func luma(img image.Image, x, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
+
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// PossibleDuplicates reports pairs of images in the gallery whose perceptual
+// hashes are at most DuplicateDistance bits apart. Exact duplicates are
+// rejected on upload, so these are usually edits, crops or re-exports.
+func (service *GalleryService) PossibleDuplicates(galleryID int) ([]PossibleDuplicate, error) {
+	images, err := service.Images(galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("possible duplicates: %w", err)
+	}
+
+	rows, err := service.DB.Query(`
+	  SELECT id, phash
+	  FROM images WHERE gallery_id=$1 AND phash IS NOT NULL`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("possible duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := map[int]uint64{}
+	for rows.Next() {
+		var id int
+		var hash int64
+		err := rows.Scan(&id, &hash)
+		if err != nil {
+			return nil, fmt.Errorf("possible duplicates: %w", err)
+		}
+		hashes[id] = uint64(hash)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("possible duplicates: %w", rows.Err())
+	}
+
+	var duplicates []PossibleDuplicate
+	for i, image := range images {
+		hash, ok := hashes[image.ID]
+		if !ok {
+			continue
+		}
+		for _, other := range images[i+1:] {
+			otherHash, ok := hashes[other.ID]
+			if !ok {
+				continue
+			}
+			distance := hashDistance(hash, otherHash)
+			if distance <= DuplicateDistance {
+				duplicates = append(duplicates, PossibleDuplicate{
+					Image:    image,
+					Other:    other,
+					Distance: distance,
+				})
+			}
+		}
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,325 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultInvitationDuration is how long gallery invitations can be
+	// accepted.
+	DefaultInvitationDuration = 7 * 24 * time.Hour
+)
+
+// Member is a user who was given a role in a gallery of another user.
+type Member struct {
+	GalleryID int
+	UserID    int
+	Email     string
+	Handle    string
+	Role      Role
+	CreatedAt time.Time
+}
+
+// Invitation asks the owner of an email address to join a gallery. Accepting
+// it makes the user who accepts a member of the gallery.
+type Invitation struct {
+	ID        int
+	GalleryID int
+	Email     string
+	Role      Role
+	// Token is only set when an Invitation is being created. We only store
+	// the hash of the token.
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// IsFor reports whether the invitation was sent to the email address.
+// Invitations can only be accepted by the user with that address.
+func (invitation Invitation) IsFor(email string) bool {
+	return strings.EqualFold(strings.TrimSpace(invitation.Email), strings.TrimSpace(email))
+}
+
+// SharedGallery is a gallery of another user together with the role the
+// member has in it.
+type SharedGallery struct {
+	Gallery
+	Role Role
+}
+
+type MemberService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+	// InvitationDuration defaults to DefaultInvitationDuration.
+	InvitationDuration time.Duration
+}
+
+// RoleOf returns the role of the user in the gallery. Visitors who are not
+// signed in and users without access have RoleNone.
+func (ms *MemberService) RoleOf(user *User, gallery *Gallery) (Role, error) {
+	if user == nil {
+		return RoleNone, nil
+	}
+	if user.ID == gallery.UserID {
+		return RoleOwner, nil
+	}
+
+	var role Role
+	row := ms.DB.QueryRow(`
+	  SELECT role FROM gallery_members
+	  WHERE gallery_id=$1 AND user_id=$2`, gallery.ID, user.ID)
+	err := row.Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RoleNone, nil
+		}
+		return RoleNone, fmt.Errorf("role of user: %w", err)
+	}
+	return role, nil
+}
+
+// Members returns the members of the gallery in the order they joined.
+func (ms *MemberService) Members(galleryID int) ([]Member, error) {
+	rows, err := ms.DB.Query(`
+	  SELECT m.user_id, u.email, u.handle, m.role, m.created_at
+	  FROM gallery_members m JOIN users u ON u.id = m.user_id
+	  WHERE m.gallery_id=$1
+	  ORDER BY m.created_at`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("gallery members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		member := Member{
+			GalleryID: galleryID,
+		}
+		err := rows.Scan(&member.UserID, &member.Email, &member.Handle,
+			&member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("gallery members: %w", err)
+		}
+		members = append(members, member)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("gallery members: %w", rows.Err())
+	}
+	return members, nil
+}
+
+func (ms *MemberService) SetRole(galleryID, userID int, role Role) error {
+	if !ValidMemberRole(role) {
+		return ErrInvalidRole
+	}
+	_, err := ms.DB.Exec(`
+	  UPDATE gallery_members SET role=$3
+	  WHERE gallery_id=$1 AND user_id=$2`, galleryID, userID, role)
+	if err != nil {
+		return fmt.Errorf("set member role: %w", err)
+	}
+	return nil
+}
+
+func (ms *MemberService) Remove(galleryID, userID int) error {
+	_, err := ms.DB.Exec(`
+	  DELETE FROM gallery_members
+	  WHERE gallery_id=$1 AND user_id=$2`, galleryID, userID)
+	if err != nil {
+		return fmt.Errorf("remove member: %w", err)
+	}
+	return nil
+}
+
+// SharedGalleries returns the galleries the user is a member of.
+func (ms *MemberService) SharedGalleries(userID int) ([]SharedGallery, error) {
+	rows, err := ms.DB.Query(`
+	  SELECT `+galleryColumns+`, m.role
+	  `+galleryFrom+`
+	  JOIN gallery_members m ON m.gallery_id = g.id
+	  WHERE m.user_id=$1 AND g.deleted_at IS NULL
+	  ORDER BY g.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("shared galleries: %w", err)
+	}
+	defer rows.Close()
+
+	var galleries []SharedGallery
+	for rows.Next() {
+		var role Role
+		gallery, err := scanGallery(withExtraColumns(rows, &role))
+		if err != nil {
+			return nil, fmt.Errorf("shared galleries: %w", err)
+		}
+		galleries = append(galleries, SharedGallery{Gallery: *gallery, Role: role})
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("shared galleries: %w", rows.Err())
+	}
+	return galleries, nil
+}
+
+// Invite creates an invitation to the gallery for the email address. The
+// token of the returned invitation has to be sent to the invitee.
+func (ms *MemberService) Invite(galleryID int, email string, role Role, invitedBy int) (*Invitation, error) {
+	if !ValidMemberRole(role) {
+		return nil, ErrInvalidRole
+	}
+	token, err := ms.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("invite member: %w", err)
+	}
+
+	duration := ms.InvitationDuration
+	if duration == 0 {
+		duration = DefaultInvitationDuration
+	}
+	invitation := Invitation{
+		GalleryID: galleryID,
+		Email:     strings.ToLower(email),
+		Role:      role,
+		Token:     token,
+		TokenHash: ms.TokenManager.Hash(token),
+		ExpiresAt: time.Now().Add(duration),
+	}
+
+	row := ms.DB.QueryRow(`
+	  INSERT INTO gallery_invitations (gallery_id, email, role, token_hash, invited_by, expires_at)
+	  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		invitation.GalleryID, invitation.Email, invitation.Role,
+		invitation.TokenHash, invitedBy, invitation.ExpiresAt)
+	err = row.Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invite member: %w", err)
+	}
+	return &invitation, nil
+}
+
+// Invitations returns the open invitations of the gallery, newest first.
+func (ms *MemberService) Invitations(galleryID int) ([]Invitation, error) {
+	rows, err := ms.DB.Query(`
+	  SELECT id, email, role, expires_at, created_at
+	  FROM gallery_invitations
+	  WHERE gallery_id=$1 AND expires_at > now()
+	  ORDER BY created_at DESC`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("gallery invitations: %w", err)
+	}
+	defer rows.Close()
+
+	var invitations []Invitation
+	for rows.Next() {
+		invitation := Invitation{
+			GalleryID: galleryID,
+		}
+		err := rows.Scan(&invitation.ID, &invitation.Email, &invitation.Role,
+			&invitation.ExpiresAt, &invitation.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("gallery invitations: %w", err)
+		}
+		invitations = append(invitations, invitation)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("gallery invitations: %w", rows.Err())
+	}
+	return invitations, nil
+}
+
+// FindInvitation returns the invitation with the token. It returns
+// ErrInvalidInvitation if the invitation doesn't exist or has expired.
+func (ms *MemberService) FindInvitation(token string) (*Invitation, error) {
+	invitation := Invitation{
+		TokenHash: ms.TokenManager.Hash(token),
+	}
+	row := ms.DB.QueryRow(`
+	  SELECT id, gallery_id, email, role, expires_at, created_at
+	  FROM gallery_invitations
+	  WHERE token_hash=$1`, invitation.TokenHash)
+	err := row.Scan(&invitation.ID, &invitation.GalleryID, &invitation.Email,
+		&invitation.Role, &invitation.ExpiresAt, &invitation.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, fmt.Errorf("find invitation: %w", err)
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrInvalidInvitation
+	}
+	return &invitation, nil
+}
+
+// AcceptInvitation consumes the invitation and makes the user a member of
+// the gallery. Only the user with the email address the invitation was sent
+// to can accept it, others get ErrInvitationForOther and the invitation stays
+// valid. Users who are already members get the role of the invitation.
+// Owners can't become members of their own galleries, for them the
+// invitation is only consumed.
+func (ms *MemberService) AcceptInvitation(token string, userID int) (*Invitation, error) {
+	tx, err := ms.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var invitation Invitation
+	var ownerID int
+	var userEmail string
+	row := tx.QueryRow(`
+	  SELECT i.id, i.gallery_id, i.email, i.role, g.user_id, u.email
+	  FROM gallery_invitations i
+	  JOIN galleries g ON g.id = i.gallery_id
+	  JOIN users u ON u.id = $2
+	  WHERE i.token_hash=$1 AND i.expires_at > now()
+	  FOR UPDATE OF i`, ms.TokenManager.Hash(token), userID)
+	err = row.Scan(&invitation.ID, &invitation.GalleryID, &invitation.Email,
+		&invitation.Role, &ownerID, &userEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	if !invitation.IsFor(userEmail) {
+		return nil, ErrInvitationForOther
+	}
+
+	_, err = tx.Exec(`
+	  DELETE FROM gallery_invitations
+	  WHERE id=$1`, invitation.ID)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	if ownerID != userID {
+		_, err = tx.Exec(`
+		  INSERT INTO gallery_members (gallery_id, user_id, role)
+		  VALUES ($1, $2, $3) ON CONFLICT (gallery_id, user_id) DO
+		  UPDATE SET role = $3`, invitation.GalleryID, userID, invitation.Role)
+		if err != nil {
+			return nil, fmt.Errorf("accept invitation: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	return &invitation, nil
+}
+
+// DeleteInvitation revokes an invitation of the gallery.
+func (ms *MemberService) DeleteInvitation(galleryID, invitationID int) error {
+	_, err := ms.DB.Exec(`
+	  DELETE FROM gallery_invitations
+	  WHERE gallery_id=$1 AND id=$2`, galleryID, invitationID)
+	if err != nil {
+		return fmt.Errorf("delete invitation: %w", err)
+	}
+	return nil
+}
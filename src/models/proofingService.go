@@ -0,0 +1,298 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MaxProofingNoteLength limits the notes on selections and on selected
+	// images in characters.
+	MaxProofingNoteLength = 1000
+	// MaxClientNameLength limits the name visitors enter when submitting a
+	// selection.
+	MaxClientNameLength = 100
+)
+
+// ProofingPick is an image in a proofing selection.
+type ProofingPick struct {
+	ImageID  int
+	ImageKey string
+	Filename string
+	Note     string
+}
+
+// ProofingSelection is the set of images a visitor picked in a gallery with
+// proofing enabled. Visitors build their selection as a draft and submit it
+// once they are done, after which it can't be changed.
+type ProofingSelection struct {
+	ID        int
+	GalleryID int
+	// Token identifies the draft of a visitor, who doesn't need an account.
+	// Only its hash is stored, so the token is only set by Save.
+	Token       string
+	ClientName  string
+	ClientEmail string
+	Note        string
+	// SubmittedAt is zero while the selection is a draft.
+	SubmittedAt time.Time
+	CreatedAt   time.Time
+	Picks       []ProofingPick
+	// OwnerEmail is only set by Save when the selection is submitted, so the
+	// owner of the gallery can be notified.
+	OwnerEmail string
+}
+
+func (selection ProofingSelection) Submitted() bool {
+	return !selection.SubmittedAt.IsZero()
+}
+
+// LightroomFilter returns the filenames of the picks without extensions,
+// separated by commas, to be pasted into the Filename text filter of the
+// Lightroom library with the Contains rule. Extensions are left out because
+// the catalog often holds the raw files the uploads were exported from. The
+// rule matches parts of names though, so the filter also shows photos whose
+// names contain a picked name, like IMG_10 for IMG_1, and names with spaces
+// are split into several terms that may match even more photos.
+func (selection ProofingSelection) LightroomFilter() string {
+	names := make([]string, 0, len(selection.Picks))
+	for _, pick := range selection.Picks {
+		names = append(names, strings.TrimSuffix(pick.Filename, filepath.Ext(pick.Filename)))
+	}
+	return strings.Join(names, ", ")
+}
+
+type ProofingService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+}
+
+// Draft returns the selection with the token in the gallery unless it has
+// been submitted already.
+func (ps *ProofingService) Draft(galleryID int, token string) (*ProofingSelection, error) {
+	row := ps.DB.QueryRow(`
+	  SELECT `+proofingSelectionColumns+`
+	  FROM proofing_selections
+	  WHERE gallery_id=$1 AND token_hash=$2 AND submitted_at IS NULL`,
+		galleryID, ps.TokenManager.Hash(token))
+	selection, err := ps.scanSelection(row)
+	if err != nil {
+		return nil, fmt.Errorf("proofing draft: %w", err)
+	}
+	return selection, nil
+}
+
+// Save stores the selection of a visitor. If the token doesn't belong to a
+// draft in the gallery, a new selection with a new token is started. Picks
+// of images that are not in the gallery are ignored. If submit is true, the
+// selection is submitted and the visitor has to enter their name.
+func (ps *ProofingService) Save(gallery Gallery, token string, details ProofingSelection, submit bool) (*ProofingSelection, error) {
+	details.ClientName = strings.TrimSpace(details.ClientName)
+	details.ClientEmail = strings.TrimSpace(details.ClientEmail)
+	details.Note = strings.TrimSpace(details.Note)
+	if gallery.ProofingLimit > 0 && len(details.Picks) > gallery.ProofingLimit {
+		return nil, ErrSelectionLimit
+	}
+	if !validProofingSelection(details, submit) {
+		return nil, ErrInvalidSelection
+	}
+
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("save selection: %w", err)
+	}
+	defer tx.Rollback()
+
+	selection := details
+	selection.GalleryID = gallery.ID
+	selection.Token = token
+	row := tx.QueryRow(`
+	  SELECT id, created_at FROM proofing_selections
+	  WHERE gallery_id=$1 AND token_hash=$2 AND submitted_at IS NULL
+	  FOR UPDATE`, gallery.ID, ps.TokenManager.Hash(token))
+	err = row.Scan(&selection.ID, &selection.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		selection.Token, err = ps.TokenManager.New()
+		if err != nil {
+			return nil, fmt.Errorf("save selection: %w", err)
+		}
+		row = tx.QueryRow(`
+		  INSERT INTO proofing_selections (gallery_id, token_hash)
+		  VALUES ($1, $2) RETURNING id, created_at`,
+			gallery.ID, ps.TokenManager.Hash(selection.Token))
+		err = row.Scan(&selection.ID, &selection.CreatedAt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("save selection: %w", err)
+	}
+
+	var submittedAt sql.NullTime
+	row = tx.QueryRow(`
+	  UPDATE proofing_selections
+	  SET client_name=$2, client_email=$3, note=$4,
+	    submitted_at=CASE WHEN $5 THEN now() END
+	  WHERE id=$1
+	  RETURNING submitted_at`, selection.ID, selection.ClientName,
+		selection.ClientEmail, selection.Note, submit)
+	err = row.Scan(&submittedAt)
+	if err != nil {
+		return nil, fmt.Errorf("save selection: %w", err)
+	}
+	selection.SubmittedAt = submittedAt.Time
+
+	_, err = tx.Exec(`
+	  DELETE FROM proofing_picks WHERE selection_id=$1`, selection.ID)
+	if err != nil {
+		return nil, fmt.Errorf("save selection: %w", err)
+	}
+	for _, pick := range selection.Picks {
+		_, err = tx.Exec(`
+		  INSERT INTO proofing_picks (selection_id, image_id, note)
+		  SELECT $1, id, $3 FROM images
+		  WHERE id=$2 AND gallery_id=$4 AND deleted_at IS NULL`,
+			selection.ID, pick.ImageID, pick.Note, gallery.ID)
+		if err != nil {
+			return nil, fmt.Errorf("save selection: %w", err)
+		}
+	}
+
+	if submit {
+		row = tx.QueryRow(`
+		  SELECT email FROM users WHERE id=$1`, gallery.UserID)
+		err = row.Scan(&selection.OwnerEmail)
+		if err != nil {
+			return nil, fmt.Errorf("save selection: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("save selection: %w", err)
+	}
+	return &selection, nil
+}
+
+func validProofingSelection(selection ProofingSelection, submit bool) bool {
+	if utf8.RuneCountInString(selection.ClientName) > MaxClientNameLength ||
+		utf8.RuneCountInString(selection.ClientEmail) > MaxClientNameLength ||
+		utf8.RuneCountInString(selection.Note) > MaxProofingNoteLength {
+		return false
+	}
+	for _, pick := range selection.Picks {
+		if utf8.RuneCountInString(pick.Note) > MaxProofingNoteLength {
+			return false
+		}
+	}
+	return !submit || (selection.ClientName != "" && len(selection.Picks) > 0)
+}
+
+// Submitted returns the submitted selections of the gallery, newest first.
+func (ps *ProofingService) Submitted(galleryID int) ([]ProofingSelection, error) {
+	rows, err := ps.DB.Query(`
+	  SELECT `+proofingSelectionColumns+`
+	  FROM proofing_selections
+	  WHERE gallery_id=$1 AND submitted_at IS NOT NULL
+	  ORDER BY submitted_at DESC`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("submitted selections: %w", err)
+	}
+	defer rows.Close()
+
+	var selections []ProofingSelection
+	for rows.Next() {
+		selection, err := scanSelectionRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("submitted selections: %w", err)
+		}
+		selections = append(selections, *selection)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("submitted selections: %w", rows.Err())
+	}
+	rows.Close()
+
+	for i := range selections {
+		selections[i].Picks, err = ps.picks(selections[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("submitted selections: %w", err)
+		}
+	}
+	return selections, nil
+}
+
+// Submission returns the submitted selection of the gallery with the ID.
+func (ps *ProofingService) Submission(galleryID, selectionID int) (*ProofingSelection, error) {
+	row := ps.DB.QueryRow(`
+	  SELECT `+proofingSelectionColumns+`
+	  FROM proofing_selections
+	  WHERE gallery_id=$1 AND id=$2 AND submitted_at IS NOT NULL`,
+		galleryID, selectionID)
+	selection, err := ps.scanSelection(row)
+	if err != nil {
+		return nil, fmt.Errorf("proofing submission: %w", err)
+	}
+	return selection, nil
+}
+
+// proofingSelectionColumns lists the columns scanned by scanSelectionRow.
+const proofingSelectionColumns = `
+	id, gallery_id, client_name, client_email, note, submitted_at, created_at`
+
+func scanSelectionRow(row scanner) (*ProofingSelection, error) {
+	var selection ProofingSelection
+	var submittedAt sql.NullTime
+	err := row.Scan(&selection.ID, &selection.GalleryID, &selection.ClientName,
+		&selection.ClientEmail, &selection.Note, &submittedAt, &selection.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	selection.SubmittedAt = submittedAt.Time
+	return &selection, nil
+}
+
+// scanSelection scans a single selection together with its picks.
+func (ps *ProofingService) scanSelection(row scanner) (*ProofingSelection, error) {
+	selection, err := scanSelectionRow(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSelectionNotFound
+		}
+		return nil, err
+	}
+	selection.Picks, err = ps.picks(selection.ID)
+	if err != nil {
+		return nil, err
+	}
+	return selection, nil
+}
+
+// picks returns the picks of the selection in the order of the gallery.
+// Images that were deleted since are still listed, as the owner may have
+// the original files elsewhere.
+func (ps *ProofingService) picks(selectionID int) ([]ProofingPick, error) {
+	rows, err := ps.DB.Query(`
+	  SELECT i.id, i.key, i.filename, p.note
+	  FROM proofing_picks p JOIN images i ON i.id = p.image_id
+	  WHERE p.selection_id=$1
+	  ORDER BY i.position, i.id`, selectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var picks []ProofingPick
+	for rows.Next() {
+		var pick ProofingPick
+		err := rows.Scan(&pick.ImageID, &pick.ImageKey, &pick.Filename, &pick.Note)
+		if err != nil {
+			return nil, err
+		}
+		picks = append(picks, pick)
+	}
+	return picks, rows.Err()
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultStorageQuota is the number of bytes each user can store when
+	// QuotaService.DefaultQuota is not set.
+	DefaultStorageQuota int64 = 1 << 30
+)
+
+type StorageUsage struct {
+	UserID int
+	Email  string
+	// Used is the number of bytes taken by images in all galleries of the
+	// user. Images sharing a blob are counted once per image, so the number
+	// doesn't depend on what other users upload.
+	Used int64
+	// Quota is the number of bytes the user is allowed to store.
+	Quota int64
+	// CustomQuota is true if an admin has overridden the default quota.
+	CustomQuota bool
+}
+
+// Remaining returns the number of bytes the user can still upload.
+func (su StorageUsage) Remaining() int64 {
+	if su.Used >= su.Quota {
+		return 0
+	}
+	return su.Quota - su.Used
+}
+
+// Percent returns how much of the quota is used, capped at 100.
+func (su StorageUsage) Percent() int {
+	if su.Quota <= 0 || su.Used >= su.Quota {
+		return 100
+	}
+	return int(su.Used * 100 / su.Quota)
+}
+
+type QuotaService struct {
+	DB *sql.DB
+	// DefaultQuota is the number of bytes each user can store unless an admin
+	// sets a different quota for them. If this value is not set,
+	// DefaultStorageQuota is used.
+	DefaultQuota int64
+}
+
+func (qs *QuotaService) defaultQuota() int64 {
+	if qs.DefaultQuota <= 0 {
+		return DefaultStorageQuota
+	}
+	return qs.DefaultQuota
+}
+
+func (qs *QuotaService) Usage(userID int) (*StorageUsage, error) {
+	usage := StorageUsage{
+		UserID: userID,
+	}
+
+	var quota sql.NullInt64
+	row := qs.DB.QueryRow(`
+	  SELECT u.email, u.storage_quota, COALESCE(SUM(i.size), 0)
+	  FROM users u
+	  LEFT JOIN galleries g ON g.user_id = u.id
+	  LEFT JOIN images i ON i.gallery_id = g.id
+	  WHERE u.id=$1
+	  GROUP BY u.id`, userID)
+	err := row.Scan(&usage.Email, &quota, &usage.Used)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, fmt.Errorf("storage usage: %w", err)
+	}
+	qs.setQuota(&usage, quota)
+
+	return &usage, nil
+}
+
+// check returns ErrQuotaExceeded if storing size more bytes would take the
+// user over their quota. The user is locked until the transaction ends, so
+// callers have to add the images in the same transaction for concurrent
+// uploads to see them.
+func (qs *QuotaService) check(tx *sql.Tx, userID int, size int64) error {
+	var quota sql.NullInt64
+	row := tx.QueryRow(`
+	  SELECT storage_quota FROM users WHERE id=$1 FOR UPDATE`, userID)
+	err := row.Scan(&quota)
+	if err != nil {
+		return fmt.Errorf("check quota: %w", err)
+	}
+	// the usage is only summed up once the lock is held, so it includes the
+	// images of uploads that were waiting for it
+	usage := StorageUsage{UserID: userID}
+	row = tx.QueryRow(`
+	  SELECT COALESCE(SUM(i.size), 0)
+	  FROM images i
+	  JOIN galleries g ON g.id = i.gallery_id
+	  WHERE g.user_id=$1`, userID)
+	err = row.Scan(&usage.Used)
+	if err != nil {
+		return fmt.Errorf("check quota: %w", err)
+	}
+	qs.setQuota(&usage, quota)
+	if size > usage.Remaining() {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// AllUsages returns the storage usage of every user, heaviest users first.
+func (qs *QuotaService) AllUsages() ([]StorageUsage, error) {
+	rows, err := qs.DB.Query(`
+	  SELECT u.id, u.email, u.storage_quota, COALESCE(SUM(i.size), 0) AS used
+	  FROM users u
+	  LEFT JOIN galleries g ON g.user_id = u.id
+	  LEFT JOIN images i ON i.gallery_id = g.id
+	  GROUP BY u.id
+	  ORDER BY used DESC, u.id`)
+	if err != nil {
+		return nil, fmt.Errorf("all storage usages: %w", err)
+	}
+	defer rows.Close()
+
+	var usages []StorageUsage
+	for rows.Next() {
+		var usage StorageUsage
+		var quota sql.NullInt64
+		err := rows.Scan(&usage.UserID, &usage.Email, &quota, &usage.Used)
+		if err != nil {
+			return nil, fmt.Errorf("all storage usages: %w", err)
+		}
+		qs.setQuota(&usage, quota)
+		usages = append(usages, usage)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("all storage usages: %w", rows.Err())
+	}
+
+	return usages, nil
+}
+
+// SetQuota overrides the default quota of the user. Passing nil resets the
+// user back to the default quota. ErrResourceNotFound is returned if there
+// is no such user.
+func (qs *QuotaService) SetQuota(userID int, quota *int64) error {
+	result, err := qs.DB.Exec(`
+	  UPDATE users
+	  SET storage_quota = $2
+	  WHERE id = $1;`, userID, quota)
+	if err != nil {
+		return fmt.Errorf("set quota: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("set quota: %w", err)
+	}
+	if affected == 0 {
+		return ErrResourceNotFound
+	}
+	return nil
+}
+
+func (qs *QuotaService) setQuota(usage *StorageUsage, quota sql.NullInt64) {
+	usage.Quota = qs.defaultQuota()
+	if quota.Valid {
+		usage.Quota = quota.Int64
+		usage.CustomQuota = true
+	}
+}
@@ -0,0 +1,335 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxReportDetailsLength limits the details reporters can add in characters.
+const MaxReportDetailsLength = 1000
+
+// ReportReason is the kind of abuse a report is about.
+type ReportReason string
+
+const (
+	ReasonSpam       ReportReason = "spam"
+	ReasonHarassment ReportReason = "harassment"
+	ReasonNudity     ReportReason = "nudity"
+	ReasonViolence   ReportReason = "violence"
+	ReasonCopyright  ReportReason = "copyright"
+	ReasonOther      ReportReason = "other"
+)
+
+// ReportReasons lists the reasons in the order they are offered to reporters.
+var ReportReasons = []ReportReason{
+	ReasonSpam, ReasonHarassment, ReasonNudity, ReasonViolence, ReasonCopyright, ReasonOther,
+}
+
+func (reason ReportReason) Valid() bool {
+	for _, valid := range ReportReasons {
+		if reason == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// Label returns the reason as shown to users.
+func (reason ReportReason) Label() string {
+	switch reason {
+	case ReasonSpam:
+		return "Spam"
+	case ReasonHarassment:
+		return "Harassment or hate speech"
+	case ReasonNudity:
+		return "Nudity or sexual content"
+	case ReasonViolence:
+		return "Violence or gore"
+	case ReasonCopyright:
+		return "Copyright infringement"
+	default:
+		return "Something else"
+	}
+}
+
+// ReportResolution is the action an admin took to close a report.
+type ReportResolution string
+
+const (
+	ResolutionDismissed   ReportResolution = "dismissed"
+	ResolutionUnpublished ReportResolution = "unpublished"
+	ResolutionSuspended   ReportResolution = "suspended"
+)
+
+// Report is a complaint of a user about a gallery, an image or a comment.
+type Report struct {
+	ID int
+	// ReporterID is 0 if the reporter's account is gone.
+	ReporterID     int
+	ReporterHandle string
+	ReporterEmail  string
+	GalleryID      int
+	GalleryTitle   string
+	GalleryPath    string
+	// ImageID is the reported image, or 0 if the report is not about an
+	// image.
+	ImageID  int
+	ImageKey string
+	// CommentID is the reported comment, or 0 if the report is not about a
+	// comment.
+	CommentID   int
+	CommentBody string
+	// OwnerID is the user responsible for the reported content: the author
+	// of a reported comment and the owner of the gallery otherwise.
+	OwnerID     int
+	OwnerHandle string
+	OwnerEmail  string
+	Reason      ReportReason
+	Details     string
+	CreatedAt   time.Time
+	// Resolution is empty while the report is open.
+	Resolution ReportResolution
+}
+
+// Content describes the reported content for emails, for example
+// `an image in the gallery "Summer"`.
+func (report Report) Content() string {
+	switch {
+	case report.CommentID != 0:
+		return fmt.Sprintf("a comment in the gallery %q", report.GalleryTitle)
+	case report.ImageID != 0:
+		return fmt.Sprintf("an image in the gallery %q", report.GalleryTitle)
+	default:
+		return fmt.Sprintf("the gallery %q", report.GalleryTitle)
+	}
+}
+
+type ReportService struct {
+	DB *sql.DB
+}
+
+// Create files a report. The gallery, image and comment are expected to be
+// checked by the caller. Reporting the same content again while the first
+// report is still open has no effect.
+func (rs *ReportService) Create(report Report) error {
+	report.Details = strings.TrimSpace(report.Details)
+	if !report.Reason.Valid() || utf8.RuneCountInString(report.Details) > MaxReportDetailsLength {
+		return ErrInvalidReport
+	}
+	_, err := rs.DB.Exec(`
+	  INSERT INTO reports (reporter_id, gallery_id, image_id, comment_id, reason, details)
+	  VALUES ($1, $2, $3, $4, $5, $6)
+	  ON CONFLICT DO NOTHING`, report.ReporterID, report.GalleryID,
+		nullID(report.ImageID), nullID(report.CommentID), report.Reason, report.Details)
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+	return nil
+}
+
+// Open returns the reports waiting for an admin, oldest first.
+func (rs *ReportService) Open() ([]Report, error) {
+	rows, err := rs.DB.Query(`
+	  SELECT ` + reportColumns + `
+	  ` + reportFrom + `
+	  WHERE r.resolved_at IS NULL
+	  ORDER BY r.created_at, r.id`)
+	if err != nil {
+		return nil, fmt.Errorf("open reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []Report
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("open reports: %w", err)
+		}
+		reports = append(reports, *report)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("open reports: %w", rows.Err())
+	}
+	return reports, nil
+}
+
+// Resolve closes the open report with the resolution and carries it out.
+// Unpublishing a gallery also closes the other open reports about it and its
+// images, and suspending a user closes the open reports about all of their
+// content, so admins don't have to go through them one by one. Comments
+// can't be unpublished, only their authors suspended.
+//
+// The closed reports are returned so their reporters can be notified, the
+// report the admin acted on first. They are all about content of the same
+// owner.
+func (rs *ReportService) Resolve(reportID, adminID int, resolution ReportResolution) ([]Report, error) {
+	tx, err := rs.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("resolve report: %w", err)
+	}
+	defer tx.Rollback()
+
+	report, err := scanReport(tx.QueryRow(`
+	  SELECT `+reportColumns+`
+	  `+reportFrom+`
+	  WHERE r.id=$1 AND r.resolved_at IS NULL
+	  FOR UPDATE OF r`, reportID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrReportNotFound
+		}
+		return nil, fmt.Errorf("resolve report: %w", err)
+	}
+
+	var condition string
+	var target int
+	switch resolution {
+	case ResolutionDismissed:
+		condition = `r.id = $3`
+		target = report.ID
+	case ResolutionUnpublished:
+		if report.CommentID != 0 {
+			return nil, ErrInvalidReport
+		}
+		_, err = tx.Exec(`
+		  UPDATE galleries
+		  SET published=false, published_at=NULL, updated_at=now()
+		  WHERE id=$1`, report.GalleryID)
+		condition = `r.gallery_id = $3 AND r.comment_id IS NULL`
+		target = report.GalleryID
+	case ResolutionSuspended:
+		_, err = tx.Exec(`
+		  UPDATE users SET suspended_at=COALESCE(suspended_at, now())
+		  WHERE id=$1`, report.OwnerID)
+		condition = `(r.comment_id IN (SELECT id FROM comments WHERE user_id = $3)
+		  OR (r.comment_id IS NULL AND r.gallery_id IN (SELECT id FROM galleries WHERE user_id = $3)))`
+		target = report.OwnerID
+	default:
+		return nil, ErrInvalidReport
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolve report: %w", err)
+	}
+
+	rows, err := tx.Query(`
+	  UPDATE reports r
+	  SET resolution=$1, resolved_by=$2, resolved_at=now()
+	  WHERE r.resolved_at IS NULL AND `+condition+`
+	  RETURNING r.id`, resolution, adminID, target)
+	if err != nil {
+		return nil, fmt.Errorf("resolve report: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("resolve report: %w", err)
+		}
+		if id == reportID {
+			ids = append([]int{id}, ids...)
+		} else {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("resolve report: %w", rows.Err())
+	}
+
+	resolved := make([]Report, 0, len(ids))
+	for _, id := range ids {
+		report, err := scanReport(tx.QueryRow(`
+		  SELECT `+reportColumns+`
+		  `+reportFrom+`
+		  WHERE r.id=$1`, id))
+		if err != nil {
+			return nil, fmt.Errorf("resolve report: %w", err)
+		}
+		resolved = append(resolved, *report)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("resolve report: %w", err)
+	}
+	return resolved, nil
+}
+
+// reportColumns lists the columns scanned by scanReport. Queries using it
+// have to select from reportFrom.
+const reportColumns = `
+	r.id, COALESCE(r.reporter_id, 0), COALESCE(ru.handle, ''), COALESCE(ru.email, ''),
+	r.gallery_id, g.title, gu.handle, g.slug,
+	COALESCE(r.image_id, 0), COALESCE(i.key, ''),
+	COALESCE(r.comment_id, 0), COALESCE(c.body, ''),
+	ou.id, ou.handle, ou.email,
+	r.reason, r.details, r.created_at, COALESCE(r.resolution, '')`
+
+// reportFrom joins the tables needed by reportColumns.
+const reportFrom = `
+	FROM reports r
+	JOIN galleries g ON g.id = r.gallery_id
+	JOIN users gu ON gu.id = g.user_id
+	LEFT JOIN users ru ON ru.id = r.reporter_id
+	LEFT JOIN images i ON i.id = r.image_id
+	LEFT JOIN comments c ON c.id = r.comment_id
+	JOIN users ou ON ou.id = COALESCE(c.user_id, g.user_id)`
+
+func scanReport(row scanner) (*Report, error) {
+	var report Report
+	gallery := Gallery{}
+	err := row.Scan(&report.ID, &report.ReporterID, &report.ReporterHandle, &report.ReporterEmail,
+		&report.GalleryID, &report.GalleryTitle, &gallery.UserHandle, &gallery.Slug,
+		&report.ImageID, &report.ImageKey,
+		&report.CommentID, &report.CommentBody,
+		&report.OwnerID, &report.OwnerHandle, &report.OwnerEmail,
+		&report.Reason, &report.Details, &report.CreatedAt, &report.Resolution)
+	if err != nil {
+		return nil, err
+	}
+	report.GalleryPath = gallery.Path()
+	return &report, nil
+}
+
+// Suspended returns the suspended users, most recently suspended first.
+func (us *UserService) Suspended() ([]User, error) {
+	rows, err := us.DB.Query(`
+	  SELECT ` + userColumns + `
+	  FROM users u
+	  WHERE u.suspended_at IS NOT NULL
+	  ORDER BY u.suspended_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("suspended users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("suspended users: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("suspended users: %w", rows.Err())
+	}
+	return users, nil
+}
+
+// Unsuspend lets a suspended user sign in again and makes their published
+// galleries public again.
+func (us *UserService) Unsuspend(userID int) error {
+	_, err := us.DB.Exec(`
+	  UPDATE users SET suspended_at=NULL WHERE id=$1`, userID)
+	if err != nil {
+		return fmt.Errorf("unsuspend user: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Headlines of search results mark the matching words with HighlightStart
+// and HighlightStop. The markers are private use characters, so they don't
+// clash with HTML and can be replaced after the headline has been escaped.
+const (
+	HighlightStart = "\ue000"
+	HighlightStop  = "\ue001"
+)
+
+// headlineOptions configures ts_headline to produce short snippets marked
+// with HighlightStart and HighlightStop.
+const headlineOptions = `StartSel=` + HighlightStart + `, StopSel=` + HighlightStop +
+	`, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "`
+
+// searchQuery defines the tsquery q used by the search queries. Web search
+// syntax lets users quote phrases, use "or" and exclude words with "-".
+const searchQuery = `
+	WITH q AS (SELECT websearch_to_tsquery('english', $1) AS query)`
+
+// searchableGalleryClause limits searches to listable galleries and the
+// galleries of the user in $2. Anonymous users search with user ID 0.
+const searchableGalleryClause = `
+	g.deleted_at IS NULL AND (g.user_id = $2 OR ` + listableGalleryClause + `)`
+
+// GallerySearchResult is a gallery matching a search. The headlines contain
+// the title and a snippet of the description with highlighted matches.
+type GallerySearchResult struct {
+	Gallery
+	TitleHeadline       string
+	DescriptionHeadline string
+}
+
+// ImageSearchResult is an image matching a search by its caption, alt text or
+// tags.
+type ImageSearchResult struct {
+	Image
+	GalleryTitle    string
+	GalleryPath     string
+	CaptionHeadline string
+}
+
+type SearchService struct {
+	DB *sql.DB
+}
+
+// Galleries returns up to limit galleries whose title, description, location
+// or tags match the query, best matches first. Signed in users find their own
+// galleries as well as listable ones, userID is 0 for anonymous users.
+func (ss *SearchService) Galleries(query string, userID int, limit int) ([]GallerySearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+	rows, err := ss.DB.Query(searchQuery+`
+	  SELECT `+galleryColumns+`,
+	    ts_headline('english', COALESCE(g.title, ''), q.query,
+	      'HighlightAll=true, StartSel=`+HighlightStart+`, StopSel=`+HighlightStop+`'),
+	    ts_headline('english', g.description, q.query, '`+headlineOptions+`')
+	  `+galleryFrom+`
+	  CROSS JOIN q
+	  WHERE `+searchableGalleryClause+` AND (
+	    g.search_vector @@ q.query OR EXISTS (
+	      SELECT 1 FROM gallery_tags gt
+	      JOIN tags t ON t.id = gt.tag_id
+	      WHERE gt.gallery_id = g.id AND t.search_vector @@ q.query))
+	  ORDER BY ts_rank(g.search_vector, q.query) + (
+	      SELECT count(*) FROM gallery_tags gt
+	      JOIN tags t ON t.id = gt.tag_id
+	      WHERE gt.gallery_id = g.id AND t.search_vector @@ q.query
+	    ) * 0.5 DESC,
+	    g.updated_at DESC
+	  LIMIT $3`, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("search galleries: %w", err)
+	}
+	defer rows.Close()
+
+	var results []GallerySearchResult
+	for rows.Next() {
+		var result GallerySearchResult
+		gallery, err := scanGallery(withExtraColumns(rows,
+			&result.TitleHeadline, &result.DescriptionHeadline))
+		if err != nil {
+			return nil, fmt.Errorf("search galleries: %w", err)
+		}
+		result.Gallery = *gallery
+		results = append(results, result)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("search galleries: %w", rows.Err())
+	}
+	return results, nil
+}
+
+// Images returns up to limit images whose caption, alt text or tags match the
+// query, best matches first. The same galleries are searched as in
+// Galleries.
+func (ss *SearchService) Images(query string, userID int, limit int) ([]ImageSearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+	rows, err := ss.DB.Query(searchQuery+`
+	  SELECT i.id, i.gallery_id, i.key, i.filename, i.caption, i.alt_text,
+	    g.title, u.handle, g.slug,
+	    ts_headline('english', i.caption, q.query, '`+headlineOptions+`')
+	  `+galleryFrom+`
+	  JOIN images i ON i.gallery_id = g.id
+	  CROSS JOIN q
+	  WHERE i.deleted_at IS NULL AND `+searchableGalleryClause+` AND (
+	    i.search_vector @@ q.query OR EXISTS (
+	      SELECT 1 FROM image_tags it
+	      JOIN tags t ON t.id = it.tag_id
+	      WHERE it.image_id = i.id AND t.search_vector @@ q.query))
+	  ORDER BY ts_rank(i.search_vector, q.query) DESC, i.id DESC
+	  LIMIT $3`, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("search images: %w", err)
+	}
+	defer rows.Close()
+
+	var results []ImageSearchResult
+	for rows.Next() {
+		var result ImageSearchResult
+		var gallery Gallery
+		err := rows.Scan(&result.ID, &result.GalleryID, &result.Key, &result.Filename,
+			&result.Caption, &result.AltText, &gallery.Title, &gallery.UserHandle, &gallery.Slug,
+			&result.CaptionHeadline)
+		if err != nil {
+			return nil, fmt.Errorf("search images: %w", err)
+		}
+		result.GalleryTitle = gallery.Title
+		result.GalleryPath = gallery.Path()
+		results = append(results, result)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("search images: %w", rows.Err())
+	}
+	return results, nil
+}
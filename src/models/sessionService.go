@@ -48,18 +48,17 @@ func (ss *SessionService) Create(userID int) (*Session, error) {
 
 func (ss *SessionService) User(token string) (*User, error) {
 	tokenHash := ss.TokenManager.Hash(token)
-	var user User
 	row := ss.DB.QueryRow(`
-	  SELECT u.id, u.email, u.password_hash
+	  SELECT `+userColumns+`
 		FROM users u JOIN sessions s ON u.id = s.user_id
-		WHERE s.token_hash = $1;`,
+		WHERE s.token_hash = $1 AND u.suspended_at IS NULL;`,
 		tokenHash)
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash)
+	user, err := scanUser(row)
 	if err != nil {
 		return nil, fmt.Errorf("user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (ss *SessionService) Delete(token string) error {
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ShareLink grants view access to an unlisted gallery to anyone who knows
+// its token.
+type ShareLink struct {
+	ID        int
+	GalleryID int
+	// Token is only set when a ShareLink is being created. We only store the
+	// hash of the token, so it cannot be shown again later.
+	Token     string
+	TokenHash string
+	// Label helps the owner remember who the link was shared with.
+	Label string
+	// ExpiresAt is zero for links that never expire.
+	ExpiresAt time.Time
+	ViewCount int
+	CreatedAt time.Time
+}
+
+// Expired reports whether the link can no longer be used.
+func (sl ShareLink) Expired() bool {
+	return !sl.ExpiresAt.IsZero() && time.Now().After(sl.ExpiresAt)
+}
+
+type ShareLinkService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+}
+
+func (sls *ShareLinkService) Create(galleryID int, label string, expiresAt time.Time) (*ShareLink, error) {
+	token, err := sls.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("create share link: %w", err)
+	}
+
+	shareLink := ShareLink{
+		GalleryID: galleryID,
+		Token:     token,
+		TokenHash: sls.TokenManager.Hash(token),
+		Label:     label,
+		ExpiresAt: expiresAt,
+	}
+
+	row := sls.DB.QueryRow(`
+	  INSERT INTO gallery_share_links (gallery_id, token_hash, label, expires_at)
+	  VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		shareLink.GalleryID, shareLink.TokenHash, shareLink.Label,
+		nullTime(shareLink.ExpiresAt))
+	err = row.Scan(&shareLink.ID, &shareLink.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create share link: %w", err)
+	}
+
+	return &shareLink, nil
+}
+
+// ForGallery returns all share links of the gallery, newest first. Expired
+// links are included, so the owner can see and revoke them.
+func (sls *ShareLinkService) ForGallery(galleryID int) ([]ShareLink, error) {
+	rows, err := sls.DB.Query(`
+	  SELECT id, token_hash, label, expires_at, view_count, created_at
+	  FROM gallery_share_links WHERE gallery_id=$1
+	  ORDER BY created_at DESC`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("share links for gallery: %w", err)
+	}
+	defer rows.Close()
+
+	var shareLinks []ShareLink
+	for rows.Next() {
+		shareLink := ShareLink{
+			GalleryID: galleryID,
+		}
+		var expiresAt sql.NullTime
+		err := rows.Scan(&shareLink.ID, &shareLink.TokenHash, &shareLink.Label,
+			&expiresAt, &shareLink.ViewCount, &shareLink.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("share links for gallery: %w", err)
+		}
+		shareLink.ExpiresAt = expiresAt.Time
+		shareLinks = append(shareLinks, shareLink)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("share links for gallery: %w", rows.Err())
+	}
+
+	return shareLinks, nil
+}
+
+// Validate returns the share link of the gallery with the given token. It
+// returns ErrInvalidShareLink if the link doesn't exist, was revoked or has
+// expired.
+func (sls *ShareLinkService) Validate(galleryID int, token string) (*ShareLink, error) {
+	shareLink := ShareLink{
+		GalleryID: galleryID,
+		TokenHash: sls.TokenManager.Hash(token),
+	}
+
+	var expiresAt sql.NullTime
+	row := sls.DB.QueryRow(`
+	  SELECT id, label, expires_at, view_count, created_at
+	  FROM gallery_share_links
+	  WHERE gallery_id=$1 AND token_hash=$2`,
+		shareLink.GalleryID, shareLink.TokenHash)
+	err := row.Scan(&shareLink.ID, &shareLink.Label, &expiresAt,
+		&shareLink.ViewCount, &shareLink.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidShareLink
+		}
+		return nil, fmt.Errorf("validate share link: %w", err)
+	}
+	shareLink.ExpiresAt = expiresAt.Time
+	if shareLink.Expired() {
+		return nil, ErrInvalidShareLink
+	}
+
+	return &shareLink, nil
+}
+
+func (sls *ShareLinkService) RecordView(shareLinkID int) error {
+	_, err := sls.DB.Exec(`
+	  UPDATE gallery_share_links
+	  SET view_count = view_count + 1
+	  WHERE id=$1`, shareLinkID)
+	if err != nil {
+		return fmt.Errorf("record share link view: %w", err)
+	}
+	return nil
+}
+
+// Delete revokes the share link. The gallery ID is required so that a link
+// can only be revoked through the gallery it belongs to.
+func (sls *ShareLinkService) Delete(galleryID, shareLinkID int) error {
+	_, err := sls.DB.Exec(`
+	  DELETE FROM gallery_share_links
+	  WHERE gallery_id=$1 AND id=$2`, galleryID, shareLinkID)
+	if err != nil {
+		return fmt.Errorf("delete share link: %w", err)
+	}
+	return nil
+}
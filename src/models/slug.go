@@ -0,0 +1,48 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// MaxSlugLength limits the length of gallery slugs and user handles, so
+	// that URLs stay readable.
+	MaxSlugLength = 60
+)
+
+var handleRe = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]*[a-z0-9])?$`)
+
+// Slugify turns text into a lowercase, URL-friendly string of ASCII letters,
+// digits and dashes. Accents are stripped, so "Café Été" becomes "cafe-ete".
+// The result is empty if the text has no letters or digits at all.
+func Slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// combining marks left over from decomposing accented letters
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		default:
+			dash = true
+		}
+		if b.Len() >= MaxSlugLength {
+			break
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// validHandle reports whether the handle can be used in URLs as is.
+func validHandle(handle string) bool {
+	return len(handle) <= MaxSlugLength && handleRe.MatchString(handle)
+}
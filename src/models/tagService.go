@@ -0,0 +1,276 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MaxTagLength limits the length of a single tag in characters.
+	MaxTagLength = 40
+	// MaxTags limits the number of tags on a gallery or image.
+	MaxTags = 20
+)
+
+// NormalizeTag turns user input into a tag. Tags are lowercase and consist of
+// letters, digits, dashes and underscores, with whitespace turned into
+// dashes. The result is empty if nothing usable is left.
+func NormalizeTag(input string) string {
+	var tag []rune
+	dash := false
+	for _, r := range strings.ToLower(input) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '_':
+			if dash && len(tag) > 0 {
+				tag = append(tag, '-')
+			}
+			dash = false
+			tag = append(tag, r)
+		case r == '-', unicode.IsSpace(r):
+			dash = true
+		}
+	}
+	if len(tag) > MaxTagLength {
+		tag = tag[:MaxTagLength]
+	}
+	return strings.TrimSuffix(string(tag), "-")
+}
+
+// ParseTags splits comma separated input into normalized tags, dropping
+// empty tags and duplicates. At most MaxTags tags are returned.
+func ParseTags(input string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, part := range strings.Split(input, ",") {
+		tag := NormalizeTag(part)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == MaxTags {
+			break
+		}
+	}
+	return tags
+}
+
+// TaggedImage is an image listed on a tag page together with the gallery it
+// belongs to.
+type TaggedImage struct {
+	Image
+	GalleryTitle string
+	GalleryPath  string
+}
+
+type TagService struct {
+	DB *sql.DB
+}
+
+// GalleryTags returns the tags of the gallery in alphabetical order.
+func (ts *TagService) GalleryTags(galleryID int) ([]string, error) {
+	rows, err := ts.DB.Query(`
+	  SELECT t.name FROM tags t
+	  JOIN gallery_tags gt ON gt.tag_id = t.id
+	  WHERE gt.gallery_id=$1
+	  ORDER BY t.name`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("gallery tags: %w", err)
+	}
+	tags, err := scanTagNames(rows)
+	if err != nil {
+		return nil, fmt.Errorf("gallery tags: %w", err)
+	}
+	return tags, nil
+}
+
+// ImageTags returns the tags of all images in the gallery by image ID.
+func (ts *TagService) ImageTags(galleryID int) (map[int][]string, error) {
+	rows, err := ts.DB.Query(`
+	  SELECT it.image_id, t.name FROM tags t
+	  JOIN image_tags it ON it.tag_id = t.id
+	  JOIN images i ON i.id = it.image_id
+	  WHERE i.gallery_id=$1
+	  ORDER BY t.name`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("image tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := map[int][]string{}
+	for rows.Next() {
+		var imageID int
+		var name string
+		err := rows.Scan(&imageID, &name)
+		if err != nil {
+			return nil, fmt.Errorf("image tags: %w", err)
+		}
+		tags[imageID] = append(tags[imageID], name)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("image tags: %w", rows.Err())
+	}
+	return tags, nil
+}
+
+// SetGalleryTags replaces the tags of the gallery. Tags are expected to be
+// normalized already, see ParseTags.
+func (ts *TagService) SetGalleryTags(galleryID int, tags []string) error {
+	err := ts.setTags(`gallery_tags`, `gallery_id`, galleryID, tags)
+	if err != nil {
+		return fmt.Errorf("set gallery tags: %w", err)
+	}
+	return nil
+}
+
+// SetImageTags replaces the tags of the image. Tags are expected to be
+// normalized already, see ParseTags.
+func (ts *TagService) SetImageTags(imageID int, tags []string) error {
+	err := ts.setTags(`image_tags`, `image_id`, imageID, tags)
+	if err != nil {
+		return fmt.Errorf("set image tags: %w", err)
+	}
+	return nil
+}
+
+// setTags replaces the tags in the join table for the row with the given ID.
+// Table and column names are never user input.
+func (ts *TagService) setTags(table, column string, id int, tags []string) error {
+	tx, err := ts.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM `+table+` WHERE `+column+`=$1`, id)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		var tagID int
+		row := tx.QueryRow(`
+		  INSERT INTO tags (name) VALUES ($1)
+		  ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+		  RETURNING id`, tag)
+		err = row.Scan(&tagID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		  INSERT INTO `+table+` (`+column+`, tag_id) VALUES ($1, $2)
+		  ON CONFLICT DO NOTHING`, id, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PublicGalleries returns the listable galleries with the tag, most recently
+// updated first.
+func (ts *TagService) PublicGalleries(tag string) ([]Gallery, error) {
+	rows, err := ts.DB.Query(`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+`
+	  JOIN gallery_tags gt ON gt.gallery_id = g.id
+	  JOIN tags t ON t.id = gt.tag_id
+	  WHERE t.name=$1 AND `+listableGalleryClause+`
+	  ORDER BY g.updated_at DESC`, tag)
+	if err != nil {
+		return nil, fmt.Errorf("public galleries with tag: %w", err)
+	}
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("public galleries with tag: %w", err)
+	}
+	return galleries, nil
+}
+
+// PublicImages returns the images with the tag in listable galleries.
+func (ts *TagService) PublicImages(tag string) ([]TaggedImage, error) {
+	rows, err := ts.DB.Query(`
+	  SELECT i.id, i.gallery_id, i.key, i.filename, i.caption, i.alt_text,
+	    g.title, u.handle, g.slug
+	  `+galleryFrom+`
+	  JOIN images i ON i.gallery_id = g.id
+	  JOIN image_tags it ON it.image_id = i.id
+	  JOIN tags t ON t.id = it.tag_id
+	  WHERE t.name=$1 AND i.deleted_at IS NULL AND `+listableGalleryClause+`
+	  ORDER BY i.id DESC`, tag)
+	if err != nil {
+		return nil, fmt.Errorf("public images with tag: %w", err)
+	}
+	defer rows.Close()
+
+	var images []TaggedImage
+	for rows.Next() {
+		var image TaggedImage
+		var gallery Gallery
+		err := rows.Scan(&image.ID, &image.GalleryID, &image.Key, &image.Filename,
+			&image.Caption, &image.AltText, &gallery.Title, &gallery.UserHandle, &gallery.Slug)
+		if err != nil {
+			return nil, fmt.Errorf("public images with tag: %w", err)
+		}
+		image.GalleryTitle = gallery.Title
+		image.GalleryPath = gallery.Path()
+		images = append(images, image)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("public images with tag: %w", rows.Err())
+	}
+	return images, nil
+}
+
+// Suggest returns up to limit tags starting with the prefix, for
+// autocompletion. Only tags on public content or on content of the user are
+// suggested, so tags of private galleries don't leak.
+func (ts *TagService) Suggest(prefix string, userID int, limit int) ([]string, error) {
+	prefix = NormalizeTag(prefix)
+	if prefix == "" {
+		return nil, nil
+	}
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+	rows, err := ts.DB.Query(`
+	  SELECT t.name FROM tags t
+	  WHERE t.name LIKE $1 AND (
+	    EXISTS (
+	      SELECT 1 FROM gallery_tags gt
+	      JOIN galleries g ON g.id = gt.gallery_id
+	      WHERE gt.tag_id = t.id AND (g.user_id = $2 OR `+listableGalleryClause+`))
+	    OR EXISTS (
+	      SELECT 1 FROM image_tags it
+	      JOIN images i ON i.id = it.image_id
+	      JOIN galleries g ON g.id = i.gallery_id
+	      WHERE it.tag_id = t.id AND (g.user_id = $2 OR `+listableGalleryClause+`)))
+	  ORDER BY t.name
+	  LIMIT $3`, pattern, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("suggest tags: %w", err)
+	}
+	tags, err := scanTagNames(rows)
+	if err != nil {
+		return nil, fmt.Errorf("suggest tags: %w", err)
+	}
+	return tags, nil
+}
+
+func scanTagNames(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var tags []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTransferDuration is how long the recipient has to accept a
+	// gallery transfer.
+	DefaultTransferDuration = 7 * 24 * time.Hour
+)
+
+// Transfer hands a gallery over to another user once the recipient accepts
+// it. A gallery has at most one pending transfer.
+type Transfer struct {
+	ID         int
+	GalleryID  int
+	FromUserID int
+	// ToUserID is only set once a user with the email of the transfer finds
+	// or accepts it. Transfers are addressed to emails, which don't need to
+	// have an account when the transfer is started.
+	ToUserID int
+	ToEmail  string
+	// Token is only set when a Transfer is being created. We only store the
+	// hash of the token.
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type TransferService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+	QuotaService *QuotaService
+	// Duration defaults to DefaultTransferDuration.
+	Duration time.Duration
+}
+
+// Start creates a transfer of the gallery to the email address, replacing any
+// pending transfer of the gallery. The token of the returned transfer has to
+// be sent to the recipient. Whether the email has an account doesn't matter,
+// so that owners can't use transfers to find out.
+func (ts *TransferService) Start(gallery Gallery, toEmail string) (*Transfer, error) {
+	token, err := ts.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("start transfer: %w", err)
+	}
+	duration := ts.Duration
+	if duration == 0 {
+		duration = DefaultTransferDuration
+	}
+	transfer := Transfer{
+		GalleryID:  gallery.ID,
+		FromUserID: gallery.UserID,
+		ToEmail:    strings.ToLower(toEmail),
+		Token:      token,
+		TokenHash:  ts.TokenManager.Hash(token),
+		ExpiresAt:  time.Now().Add(duration),
+	}
+
+	var ownerEmail string
+	row := ts.DB.QueryRow(`
+	  SELECT email FROM users WHERE id=$1`, transfer.FromUserID)
+	err = row.Scan(&ownerEmail)
+	if err != nil {
+		return nil, fmt.Errorf("start transfer: %w", err)
+	}
+	if transfer.ToEmail == ownerEmail {
+		return nil, ErrInvalidTransfer
+	}
+
+	row = ts.DB.QueryRow(`
+	  INSERT INTO gallery_transfers (gallery_id, from_user_id, to_email, token_hash, expires_at)
+	  VALUES ($1, $2, $3, $4, $5) ON CONFLICT (gallery_id) DO
+	  UPDATE SET from_user_id = $2, to_email = $3, token_hash = $4,
+	    expires_at = $5, created_at = now()
+	  RETURNING id, created_at`,
+		transfer.GalleryID, transfer.FromUserID, transfer.ToEmail,
+		transfer.TokenHash, transfer.ExpiresAt)
+	err = row.Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("start transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// Pending returns the pending transfer of the gallery, or nil if there is
+// none.
+func (ts *TransferService) Pending(galleryID int) (*Transfer, error) {
+	transfer := Transfer{
+		GalleryID: galleryID,
+	}
+	row := ts.DB.QueryRow(`
+	  SELECT id, from_user_id, to_email, expires_at, created_at
+	  FROM gallery_transfers
+	  WHERE gallery_id=$1 AND expires_at > now()`, galleryID)
+	err := row.Scan(&transfer.ID, &transfer.FromUserID,
+		&transfer.ToEmail, &transfer.ExpiresAt, &transfer.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("pending transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// Find returns the transfer with the token if it is addressed to the email of
+// the user. It returns ErrInvalidTransfer otherwise or if the transfer has
+// expired.
+func (ts *TransferService) Find(token string, userID int) (*Transfer, error) {
+	transfer := Transfer{
+		ToUserID:  userID,
+		TokenHash: ts.TokenManager.Hash(token),
+	}
+	row := ts.DB.QueryRow(`
+	  SELECT t.id, t.gallery_id, t.from_user_id, t.to_email, t.expires_at, t.created_at
+	  FROM gallery_transfers t JOIN users u ON u.email = t.to_email
+	  WHERE t.token_hash=$1 AND u.id=$2 AND t.expires_at > now()`,
+		transfer.TokenHash, transfer.ToUserID)
+	err := row.Scan(&transfer.ID, &transfer.GalleryID, &transfer.FromUserID,
+		&transfer.ToEmail, &transfer.ExpiresAt, &transfer.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidTransfer
+		}
+		return nil, fmt.Errorf("find transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// Cancel drops the pending transfer of the gallery, if any.
+func (ts *TransferService) Cancel(galleryID int) error {
+	_, err := ts.DB.Exec(`
+	  DELETE FROM gallery_transfers
+	  WHERE gallery_id=$1`, galleryID)
+	if err != nil {
+		return fmt.Errorf("cancel transfer: %w", err)
+	}
+	return nil
+}
+
+// Accept makes the user the owner of the gallery of the transfer. Images,
+// share links and members stay with the gallery, only the recipient stops
+// being a member since they are the owner now. The gallery gets a slug that
+// is unique among the galleries of the recipient, and the transfer is
+// recorded in the audit log. Links to the gallery under the previous owner
+// keep working, as the old slugs stay in the history.
+func (ts *TransferService) Accept(token string, userID int) (*Transfer, error) {
+	tx, err := ts.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	defer tx.Rollback()
+
+	transfer := Transfer{
+		ToUserID:  userID,
+		TokenHash: ts.TokenManager.Hash(token),
+	}
+	row := tx.QueryRow(`
+	  DELETE FROM gallery_transfers t
+	  USING users u
+	  WHERE t.token_hash=$1 AND u.id=$2 AND u.email = t.to_email AND t.expires_at > now()
+	  RETURNING t.id, t.gallery_id, t.from_user_id, t.to_email`, transfer.TokenHash, transfer.ToUserID)
+	err = row.Scan(&transfer.ID, &transfer.GalleryID, &transfer.FromUserID, &transfer.ToEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidTransfer
+		}
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	var ownerID int
+	var title, oldSlug string
+	var size int64
+	var deleted bool
+	row = tx.QueryRow(`
+	  SELECT user_id, COALESCE(title, ''), slug,
+	    (SELECT COALESCE(SUM(size), 0) FROM images WHERE gallery_id = galleries.id),
+	    deleted_at IS NOT NULL
+	  FROM galleries WHERE id=$1 FOR UPDATE`, transfer.GalleryID)
+	err = row.Scan(&ownerID, &title, &oldSlug, &size, &deleted)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	// the gallery may have changed hands or been deleted since the transfer
+	// was started, and the owner may have taken the email of the transfer
+	if ownerID != transfer.FromUserID || ownerID == transfer.ToUserID || deleted {
+		return nil, ErrInvalidTransfer
+	}
+
+	err = ts.QuotaService.check(tx, transfer.ToUserID, size)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	newSlug, err := uniqueSlug(tx, transfer.ToUserID, title, transfer.GalleryID)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	_, err = tx.Exec(`
+	  UPDATE galleries SET user_id=$2, slug=$3, updated_at=now()
+	  WHERE id=$1`, transfer.GalleryID, transfer.ToUserID, newSlug)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	// the current URL under the previous owner redirects like the old slugs
+	// already in the history, and the new slug may be an old slug of the
+	// gallery coming back to a previous owner
+	_, err = tx.Exec(`
+	  INSERT INTO gallery_slug_history (user_id, slug, gallery_id)
+	  VALUES ($1, $2, $3)
+	  ON CONFLICT (user_id, slug) DO NOTHING`, transfer.FromUserID, oldSlug, transfer.GalleryID)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	_, err = tx.Exec(`
+	  DELETE FROM gallery_slug_history
+	  WHERE user_id=$1 AND slug=$2`, transfer.ToUserID, newSlug)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	_, err = tx.Exec(`
+	  DELETE FROM gallery_members
+	  WHERE gallery_id=$1 AND user_id=$2`, transfer.GalleryID, transfer.ToUserID)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	err = recordAudit(tx, transfer.ToUserID, AuditGalleryTransferred, transfer.GalleryID,
+		map[string]any{
+			"from_user_id": transfer.FromUserID,
+			"to_user_id":   transfer.ToUserID,
+			"old_slug":     oldSlug,
+			"new_slug":     newSlug,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	return &transfer, nil
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Deleted galleries and images are moved to the trash first. They stay there
+// for the trash retention period and are purged from the database and disk
+// afterwards. Trashed images still count against the storage quota until
+// they are purged.
+
+const (
+	// DefaultTrashRetention is how long deleted galleries and images can be
+	// restored.
+	DefaultTrashRetention = 30 * 24 * time.Hour
+)
+
+func (gs *GalleryService) trashRetention() time.Duration {
+	if gs.TrashRetention <= 0 {
+		return DefaultTrashRetention
+	}
+	return gs.TrashRetention
+}
+
+// PurgesAt returns when an item deleted at deletedAt is removed for good.
+func (gs *GalleryService) PurgesAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(gs.trashRetention())
+}
+
+// TrashedGalleries returns the galleries of the user in the trash, most
+// recently deleted first.
+func (gs *GalleryService) TrashedGalleries(userID int) ([]Gallery, error) {
+	rows, err := gs.DB.Query(`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+` WHERE g.user_id=$1 AND g.deleted_at IS NOT NULL
+	  ORDER BY g.deleted_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("trashed galleries: %w", err)
+	}
+
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return nil, fmt.Errorf("trashed galleries: %w", err)
+	}
+	return galleries, nil
+}
+
+// FindTrashed looks up a gallery in the trash.
+func (gs *GalleryService) FindTrashed(id int) (*Gallery, error) {
+	row := gs.DB.QueryRow(`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+` WHERE g.id=$1 AND g.deleted_at IS NOT NULL`, id)
+	gallery, err := scanGallery(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, fmt.Errorf("find trashed gallery: %w", err)
+	}
+	return gallery, nil
+}
+
+func (gs *GalleryService) RestoreGallery(id int) error {
+	_, err := gs.DB.Exec(`
+	  UPDATE galleries SET deleted_at=NULL
+	  WHERE id=$1`, id)
+	if err != nil {
+		return fmt.Errorf("restore gallery: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes the gallery and its images for good.
+func (gs *GalleryService) Purge(gallery Gallery) error {
+	tx, err := gs.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("purge gallery: %w", err)
+	}
+	defer tx.Rollback()
+	var files blobFiles
+	defer files.rollback()
+
+	hashes, err := imageHashes(tx, gallery.ID)
+	if err != nil {
+		return fmt.Errorf("purge gallery: %w", err)
+	}
+	_, err = tx.Exec(`
+	  DELETE FROM galleries
+	  WHERE id=$1`, gallery.ID)
+	if err != nil {
+		return fmt.Errorf("purge gallery: %w", err)
+	}
+	for _, hash := range hashes {
+		err = gs.releaseBlob(tx, &files, hash)
+		if err != nil {
+			return fmt.Errorf("purge gallery: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("purge gallery: %w", err)
+	}
+	err = files.commit()
+	if err != nil {
+		return fmt.Errorf("purge gallery: %w", err)
+	}
+
+	// images uploaded before deduplication are stored in the gallery directory
+	err = os.RemoveAll(gs.galleryDir(gallery.ID))
+	if err != nil {
+		return fmt.Errorf("purge gallery images: %w", err)
+	}
+	return nil
+}
+
+// TrashedImages returns the images of the gallery in the trash, most
+// recently deleted first.
+func (gs *GalleryService) TrashedImages(galleryID int) ([]Image, error) {
+	images, err := gs.queryImages(`
+	  WHERE gallery_id=$1 AND deleted_at IS NOT NULL
+	  ORDER BY deleted_at DESC`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("trashed images: %w", err)
+	}
+	return images, nil
+}
+
+// TrashedImage looks up an image of the gallery in the trash.
+func (gs *GalleryService) TrashedImage(galleryID int, key string) (Image, error) {
+	images, err := gs.queryImages(`
+	  WHERE gallery_id=$1 AND key=$2 AND deleted_at IS NOT NULL`, galleryID, key)
+	if err != nil {
+		return Image{}, fmt.Errorf("trashed image: %w", err)
+	}
+	if len(images) == 0 {
+		return Image{}, ErrImageNotFound
+	}
+	return images[0], nil
+}
+
+// RestoreImage brings the image back from the trash. It goes back to its old
+// position unless another image took it meanwhile, in which case it is added
+// at the end.
+// If the same contents were uploaded to the gallery again meanwhile,
+// ErrDuplicateImage is returned.
+func (gs *GalleryService) RestoreImage(galleryID int, key string) error {
+	_, err := gs.DB.Exec(`
+	  UPDATE images i SET deleted_at=NULL,
+	    position=CASE WHEN EXISTS (
+	      SELECT 1 FROM images o
+	      WHERE o.gallery_id = i.gallery_id AND o.position = i.position
+	        AND o.deleted_at IS NULL)
+	    THEN (SELECT COALESCE(MAX(position), 0) + 1 FROM images WHERE gallery_id=$1)
+	    ELSE i.position END
+	  WHERE i.gallery_id=$1 AND i.key=$2 AND i.deleted_at IS NOT NULL`, galleryID, key)
+	if err != nil {
+		if isSqlUniqueViolation(err) {
+			err = ErrDuplicateImage
+		}
+		return fmt.Errorf("restore image: %w", err)
+	}
+	return nil
+}
+
+// PurgeImage deletes the image for good, whether it is in the trash or not.
+func (gs *GalleryService) PurgeImage(galleryID int, key string) error {
+	tx, err := gs.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("purge image: %w", err)
+	}
+	defer tx.Rollback()
+	var files blobFiles
+	defer files.rollback()
+
+	var path, hash string
+	row := tx.QueryRow(`
+	  DELETE FROM images
+	  WHERE gallery_id=$1 AND key=$2
+	  RETURNING path, COALESCE(sha256, '')`, galleryID, key)
+	err = row.Scan(&path, &hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrImageNotFound
+		}
+		return fmt.Errorf("purge image: %w", err)
+	}
+	if hash != "" {
+		err = gs.releaseBlob(tx, &files, hash)
+	} else {
+		// images uploaded before deduplication have a file of their own
+		err = files.remove(gs.imagePath(path))
+	}
+	if err != nil {
+		return fmt.Errorf("purge image: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("purge image: %w", err)
+	}
+	err = files.commit()
+	if err != nil {
+		return fmt.Errorf("purge image: %w", err)
+	}
+	return nil
+}
+
+// PurgeTrash removes all galleries and images that have been in the trash for
+// longer than the retention period. It keeps going when an item fails to be
+// purged, so that one broken item doesn't block the rest, and returns the
+// first error.
+func (gs *GalleryService) PurgeTrash() error {
+	cutoff := time.Now().Add(-gs.trashRetention())
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("purge trash: %w", err)
+		}
+	}
+
+	rows, err := gs.DB.Query(`
+	  SELECT `+galleryColumns+`
+	  `+galleryFrom+` WHERE g.deleted_at < $1`, cutoff)
+	if err != nil {
+		return fmt.Errorf("purge trash: %w", err)
+	}
+	galleries, err := scanGalleries(rows)
+	if err != nil {
+		return fmt.Errorf("purge trash: %w", err)
+	}
+	for _, gallery := range galleries {
+		keep(gs.Purge(gallery))
+	}
+
+	images, err := gs.queryImages(`
+	  WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return fmt.Errorf("purge trash: %w", err)
+	}
+	for _, image := range images {
+		err = gs.PurgeImage(image.GalleryID, image.Key)
+		if errors.Is(err, ErrImageNotFound) {
+			continue
+		}
+		keep(err)
+	}
+	return firstErr
+}
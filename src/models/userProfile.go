@@ -0,0 +1,164 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Shamanskiy/lenslocked/src/rand"
+)
+
+const (
+	MaxDisplayNameLength = 80
+	MaxBioLength         = 500
+
+	avatarsDirName = "avatars"
+)
+
+// ByHandle returns the user with the handle, or ErrResourceNotFound. Users
+// are also found by their previous handles, so callers should compare the
+// handle of the returned user with the requested one and redirect if they
+// differ.
+func (us *UserService) ByHandle(handle string) (*User, error) {
+	row := us.DB.QueryRow(`
+	  SELECT `+userColumns+`
+	  FROM users u WHERE u.id=`+userIDByHandle, strings.ToLower(handle))
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrResourceNotFound
+		}
+		return nil, fmt.Errorf("user by handle: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateProfile saves the display name and bio of the user. Both are trimmed
+// and ErrProfileTooLong is returned if either exceeds its maximum length.
+func (us *UserService) UpdateProfile(userID int, displayName, bio string) error {
+	displayName = strings.TrimSpace(displayName)
+	bio = strings.TrimSpace(bio)
+	if utf8.RuneCountInString(displayName) > MaxDisplayNameLength ||
+		utf8.RuneCountInString(bio) > MaxBioLength {
+		return ErrProfileTooLong
+	}
+	_, err := us.DB.Exec(`
+	  UPDATE users
+	  SET display_name=$2, bio=$3
+	  WHERE id=$1`, userID, displayName, bio)
+	if err != nil {
+		return fmt.Errorf("update profile: %w", err)
+	}
+	return nil
+}
+
+// SetAvatar replaces the avatar of the user with the uploaded image. Avatars
+// go through the same validation as gallery images and a FileError is
+// returned for unsupported files.
+func (us *UserService) SetAvatar(userID int, filename string, contents io.ReadSeeker) error {
+	err := validateImageFile(filename, contents)
+	if err != nil {
+		return fmt.Errorf("set avatar: %w", err)
+	}
+
+	key, err := rand.String(BytesPerImageKey)
+	if err != nil {
+		return fmt.Errorf("set avatar: %w", err)
+	}
+	path := avatarsDirName + "/" + key + strings.ToLower(filepath.Ext(filename))
+	err = os.MkdirAll(filepath.Join(us.imagesDir(), avatarsDirName), 0755)
+	if err != nil {
+		return fmt.Errorf("set avatar: %w", err)
+	}
+	file, err := os.Create(us.avatarPath(path))
+	if err != nil {
+		return fmt.Errorf("set avatar: %w", err)
+	}
+	_, err = io.Copy(file, contents)
+	file.Close()
+	if err != nil {
+		os.Remove(us.avatarPath(path))
+		return fmt.Errorf("set avatar: %w", err)
+	}
+
+	oldPath, err := us.replaceAvatar(userID, key, path)
+	if err != nil {
+		os.Remove(us.avatarPath(path))
+		return fmt.Errorf("set avatar: %w", err)
+	}
+	us.removeAvatarFile(oldPath)
+	return nil
+}
+
+// RemoveAvatar deletes the avatar of the user, if there is one.
+func (us *UserService) RemoveAvatar(userID int) error {
+	oldPath, err := us.replaceAvatar(userID, "", "")
+	if err != nil {
+		return fmt.Errorf("remove avatar: %w", err)
+	}
+	us.removeAvatarFile(oldPath)
+	return nil
+}
+
+// Avatar returns the path on disk of the avatar with the key, or
+// ErrAvatarNotFound.
+func (us *UserService) Avatar(key string) (string, error) {
+	var path string
+	row := us.DB.QueryRow(`
+	  SELECT avatar_path FROM users
+	  WHERE avatar_key=$1`, key)
+	err := row.Scan(&path)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrAvatarNotFound
+		}
+		return "", fmt.Errorf("avatar: %w", err)
+	}
+	return us.avatarPath(path), nil
+}
+
+// replaceAvatar stores the new avatar key and path of the user and returns
+// the path of the previous avatar. Empty values remove the avatar.
+func (us *UserService) replaceAvatar(userID int, key, path string) (string, error) {
+	var oldPath sql.NullString
+	row := us.DB.QueryRow(`
+	  UPDATE users u
+	  SET avatar_key=NULLIF($2, ''), avatar_path=NULLIF($3, '')
+	  FROM (SELECT avatar_path FROM users WHERE id=$1 FOR UPDATE) old
+	  WHERE u.id=$1
+	  RETURNING old.avatar_path`, userID, key, path)
+	err := row.Scan(&oldPath)
+	if err != nil {
+		return "", err
+	}
+	return oldPath.String, nil
+}
+
+func (us *UserService) removeAvatarFile(path string) {
+	if path == "" {
+		return
+	}
+	err := os.Remove(us.avatarPath(path))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Printf("removing avatar %v: %v\n", path, err)
+	}
+}
+
+func (us *UserService) imagesDir() string {
+	if us.ImagesDir == "" {
+		return "images"
+	}
+	return us.ImagesDir
+}
+
+// avatarPath converts a path stored in the users table, which is relative to
+// ImagesDir, into a path on disk.
+func (us *UserService) avatarPath(path string) string {
+	return filepath.Join(us.imagesDir(), filepath.FromSlash(path))
+}
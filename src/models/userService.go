@@ -5,18 +5,65 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID           int
-	Email        string
+	ID    int
+	Email string
+	// Handle is the unique name of the user used in public URLs.
+	Handle       string
 	PasswordHash string
+	IsAdmin      bool
+	// DisplayName and Bio are shown on the public profile page. Both are
+	// optional.
+	DisplayName string
+	Bio         string
+	// AvatarKey identifies the avatar image in its URL, or is empty if the
+	// user has no avatar. It changes with every upload.
+	AvatarKey string
+	// DigestEnabled users get a periodic email with their timeline.
+	DigestEnabled bool
+	// SuspendedAt is when an admin suspended the user, or zero. Suspended
+	// users can't sign in.
+	SuspendedAt time.Time
+}
+
+// Name returns the name to show for the user, which is the display name if
+// the user has set one and the handle otherwise.
+func (user User) Name() string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	return user.Handle
+}
+
+// userColumns lists the columns scanned by scanUser. Queries using it have to
+// select from users u.
+const userColumns = `
+	u.id, u.email, u.handle, u.password_hash, u.is_admin,
+	u.display_name, u.bio, COALESCE(u.avatar_key, ''), u.digest_enabled, u.suspended_at`
+
+func scanUser(row scanner) (*User, error) {
+	var user User
+	var suspendedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Email, &user.Handle, &user.PasswordHash, &user.IsAdmin,
+		&user.DisplayName, &user.Bio, &user.AvatarKey, &user.DigestEnabled, &suspendedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.SuspendedAt = suspendedAt.Time
+	return &user, nil
 }
 
 type UserService struct {
 	DB *sql.DB
+
+	// ImagesDir is where avatars are stored, in the avatars subdirectory.
+	// Defaults to the "images" directory like GalleryService.ImagesDir.
+	ImagesDir string
 }
 
 func (us *UserService) Create(email, password string) (*User, error) {
@@ -28,15 +75,21 @@ func (us *UserService) Create(email, password string) (*User, error) {
 	}
 	passwordHash := string(hashedBytes)
 
+	handle, err := us.uniqueHandle(Slugify(strings.Split(email, "@")[0]))
+	if err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
 	user := User{
 		Email:        email,
+		Handle:       handle,
 		PasswordHash: passwordHash,
 	}
 
 	row := us.DB.QueryRow(`
-	  INSERT INTO users (email, password_hash)
-	  VALUES ($1, $2) RETURNING id`,
-		user.Email, user.PasswordHash)
+	  INSERT INTO users (email, handle, password_hash)
+	  VALUES ($1, $2, $3) RETURNING id`,
+		user.Email, user.Handle, user.PasswordHash)
 	err = row.Scan(&user.ID)
 
 	if err != nil {
@@ -55,10 +108,11 @@ func (us *UserService) Authenticate(email, password string) (*User, error) {
 		Email: email,
 	}
 
+	var suspended bool
 	row := us.DB.QueryRow(`
-	  SELECT id, password_hash 
+	  SELECT id, password_hash, suspended_at IS NOT NULL
 	  FROM users WHERE email=$1`, email)
-	err := row.Scan(&user.ID, &user.PasswordHash)
+	err := row.Scan(&user.ID, &user.PasswordHash, &suspended)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrEmailNotFound
@@ -73,6 +127,10 @@ func (us *UserService) Authenticate(email, password string) (*User, error) {
 		}
 		return nil, fmt.Errorf("authenticate: %w", err)
 	}
+	// only tell users they are suspended once they proved who they are
+	if suspended {
+		return nil, ErrUserSuspended
+	}
 
 	return &user, nil
 }
@@ -92,3 +150,105 @@ func (us *UserService) UpdatePassword(userID int, password string) error {
 	}
 	return nil
 }
+
+// userIDByHandle selects the ID of the user who has or had the handle in $1.
+// Previous handles stay reserved for their users, so there is at most one.
+const userIDByHandle = `(
+	  SELECT id FROM users WHERE handle=$1
+	  UNION ALL
+	  SELECT user_id FROM user_handle_history WHERE handle=$1
+	  LIMIT 1)`
+
+// UpdateHandle changes the handle of the user. Handles may only contain
+// lowercase letters, digits and dashes, and have to be unique. The previous
+// handle is kept in the history, so that links using it keep working and no
+// one else can take it. Users may go back to their own previous handles.
+func (us *UserService) UpdateHandle(userID int, handle string) error {
+	handle = strings.ToLower(strings.TrimSpace(handle))
+	if !validHandle(handle) {
+		return ErrInvalidHandle
+	}
+
+	tx, err := us.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("update handle: %w", err)
+	}
+	defer tx.Rollback()
+
+	// handle changes are rare, so they simply take turns to keep two users
+	// from taking each other's previous handles at the same time
+	_, err = tx.Exec(`LOCK TABLE user_handle_history IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return fmt.Errorf("update handle: %w", err)
+	}
+	var oldHandle string
+	err = tx.QueryRow(`
+	  SELECT handle FROM users WHERE id=$1 FOR UPDATE`, userID).Scan(&oldHandle)
+	if err != nil {
+		return fmt.Errorf("update handle: %w", err)
+	}
+	if oldHandle == handle {
+		return nil
+	}
+	var reserved bool
+	err = tx.QueryRow(`
+	  SELECT EXISTS (
+	    SELECT 1 FROM user_handle_history WHERE handle=$1 AND user_id<>$2)`,
+		handle, userID).Scan(&reserved)
+	if err != nil {
+		return fmt.Errorf("update handle: %w", err)
+	}
+	if reserved {
+		return ErrHandleTaken
+	}
+
+	_, err = tx.Exec(`
+	  DELETE FROM user_handle_history WHERE handle=$1`, handle)
+	if err != nil {
+		return fmt.Errorf("update handle: %w", err)
+	}
+	_, err = tx.Exec(`
+	  UPDATE users
+		SET handle = $2
+		WHERE id = $1;`, userID, handle)
+	if err != nil {
+		if isSqlUniqueViolation(err) {
+			return ErrHandleTaken
+		}
+		return fmt.Errorf("update handle: %w", err)
+	}
+	_, err = tx.Exec(`
+	  INSERT INTO user_handle_history (user_id, handle)
+	  VALUES ($1, $2)`, userID, oldHandle)
+	if err != nil {
+		return fmt.Errorf("update handle: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("update handle: %w", err)
+	}
+	return nil
+}
+
+// uniqueHandle returns the first free handle out of base, base-2, base-3 and
+// so on. Previous handles of other users are not free.
+func (us *UserService) uniqueHandle(base string) (string, error) {
+	if base == "" {
+		base = "user"
+	}
+	handle := base
+	for i := 2; ; i++ {
+		var taken bool
+		row := us.DB.QueryRow(`
+		  SELECT EXISTS `+userIDByHandle, handle)
+		err := row.Scan(&taken)
+		if err != nil {
+			return "", fmt.Errorf("unique handle: %w", err)
+		}
+		if !taken {
+			return handle, nil
+		}
+		handle = fmt.Sprintf("%s-%d", base, i)
+	}
+}
@@ -0,0 +1,94 @@
+// Package ratelimit counts attempts per key in fixed time windows. It keeps
+// all counters in memory, so limits are per server instance.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type window struct {
+	count   int
+	resetAt time.Time
+}
+
+// Limiter allows at most Limit attempts per key within each Window.
+type Limiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+func New(limit int, per time.Duration) *Limiter {
+	return &Limiter{
+		Limit:   limit,
+		Window:  per,
+		windows: make(map[string]*window),
+	}
+}
+
+// Allow reports whether another attempt for the key is allowed without
+// counting it. Use Hit to count an attempt.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.current(key)
+	return w == nil || w.count < l.Limit
+}
+
+// Hit counts an attempt for the key and reports whether it was within the
+// limit. Attempts that do slow work, like checking a password, should be
+// counted with Hit before the work starts. Checking with Allow and counting
+// afterwards lets concurrent attempts slip past the limit.
+func (l *Limiter) Hit(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.current(key)
+	if w == nil {
+		l.prune()
+		w = &window{resetAt: time.Now().Add(l.Window)}
+		l.windows[key] = w
+	}
+	w.count++
+	return w.count <= l.Limit
+}
+
+// RetryAfter returns how long the key has to wait until it is allowed again.
+func (l *Limiter) RetryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.current(key)
+	if w == nil || w.count < l.Limit {
+		return 0
+	}
+	return time.Until(w.resetAt)
+}
+
+// Reset forgets all attempts of the key, e.g. after a successful login.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.windows, key)
+}
+
+// current returns the window of the key, or nil if it has expired.
+func (l *Limiter) current(key string) *window {
+	w, ok := l.windows[key]
+	if !ok || time.Now().After(w.resetAt) {
+		return nil
+	}
+	return w
+}
+
+// prune drops expired windows, so that keys that are never seen again don't
+// pile up in memory.
+func (l *Limiter) prune() {
+	now := time.Now()
+	for key, w := range l.windows {
+		if now.After(w.resetAt) {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -2,5 +2,5 @@ package templates
 
 import "embed"
 
-//go:embed *.gohtml users/*.gohtml galleries/*.gohtml
+//go:embed *.gohtml users/*.gohtml galleries/*.gohtml admin/*.gohtml
 var FS embed.FS
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 
 	"github.com/Shamanskiy/lenslocked/src/http/context"
+	"github.com/Shamanskiy/lenslocked/src/markdown"
 	"github.com/Shamanskiy/lenslocked/src/models"
 	"github.com/gorilla/csrf"
 )
@@ -74,6 +75,7 @@ func ParseFS(fs fs.FS, patterns ...string) (Template, error) {
 		"errors": func() []string {
 			return nil
 		},
+		"markdown": markdown.Render,
 	})
 
 	htmlTemplate, err := htmlTemplate.ParseFS(fs, patterns...)